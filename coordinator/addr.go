@@ -0,0 +1,33 @@
+package coordinator
+
+import "net"
+
+// isPublic reports whether a bind address accepts connections from other
+// machines. Hostnames other than localhost are assumed to be public.
+func isPublic(host string) bool {
+	if host == "" {
+		return true
+	}
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+// ClientHost returns the host a client should connect to in order to reach a
+// daemon bound to the given address
+func ClientHost(bind string) string {
+	ip := net.ParseIP(bind)
+	switch {
+	case bind == "":
+		return "127.0.0.1"
+	case ip == nil:
+		return bind
+	case ip.IsUnspecified() && ip.To4() == nil:
+		return "::1"
+	case ip.IsUnspecified():
+		return "127.0.0.1"
+	}
+	return bind
+}
@@ -1,57 +1,162 @@
 package coordinator
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/davinche/godown/dispatch"
+	"github.com/davinche/godown/render"
 	"github.com/davinche/godown/server"
 	"github.com/davinche/godown/sources"
 )
 
+// Version is the version of godown reported by the status endpoint
+var Version string
+
 // Coordinator orchestrates incoming requests
 type Coordinator struct {
-	listener net.Listener
-	port     int
-	done     chan struct{}
-	sources  []sources.Source
+	listener   net.Listener
+	port       int
+	started    time.Time
+	done       chan struct{}
+	dispatcher *dispatch.Dispatcher
+	sources    []sources.Source
+	mem        *sources.Mem
+	renderers  *render.Registry
+	auth       *server.Auth
+
+	// the command run when a preview asks the editor to show a line
+	editorCommand string
+
+	// how long the daemon keeps running with nothing to preview
+	exitAfter time.Duration
+	idle      *time.Timer
+	idleLock  sync.Mutex
 }
 
-// New is the constructor for request coordination
-func New(port int) (*Coordinator, error) {
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+// New is the constructor for request coordination. The daemon listens on the
+// bind address, which should be a loopback address unless the previews are
+// meant to be shared. The sources are created up front so that they can be
+// queried while Serve is starting up.
+func New(bind string, port int) (*Coordinator, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(bind, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if isPublic(bind) {
+		log.Printf("coordinator warning: listening on a public address: addr=%q\n", listener.Addr())
+	}
+
+	// the session secret authorizes the CLI helpers
+	secret, err := server.NewSecret()
+	if err == nil {
+		err = server.WriteSecret(port, secret, bind)
+	}
 	if err != nil {
+		listener.Close()
 		return nil, err
 	}
-	return &Coordinator{
-		listener: listener,
-		port:     port,
-		done:     make(chan struct{}),
-		sources:  make([]sources.Source, 0),
-	}, nil
+
+	c := &Coordinator{
+		listener:   listener,
+		port:       port,
+		started:    time.Now(),
+		done:       make(chan struct{}),
+		dispatcher: dispatch.NewDispatcher(),
+		renderers:  render.NewRegistry(),
+		auth:       server.NewAuth(secret, isPublic(bind)),
+	}
+
+	// Sources of markdown
+	fileSource := sources.NewFile(c.dispatcher, c.renderers)
+	memSource := sources.NewMem(c.dispatcher, c.renderers)
+	dirSource := sources.NewDir(c.dispatcher, c.renderers)
+	c.dispatcher.AddHandler(fileSource)
+	c.dispatcher.AddHandler(memSource)
+	c.dispatcher.AddHandler(dirSource)
+
+	// Track sources
+	c.sources = []sources.Source{fileSource, memSource, dirSource}
+	c.mem = memSource
+	return c, nil
+}
+
+// Addr returns the address the daemon is listening on
+func (c *Coordinator) Addr() net.Addr {
+	return c.listener.Addr()
+}
+
+// Public reports whether the daemon can be reached from other machines
+func (c *Coordinator) Public() bool {
+	host, _, err := net.SplitHostPort(c.listener.Addr().String())
+	return err != nil || isPublic(host)
+}
+
+// Renderers returns the registry of markdown renderers that is handed to
+// every source. Register additional renderers before calling Serve.
+func (c *Coordinator) Renderers() *render.Registry {
+	return c.renderers
+}
+
+// UseEditorCommand sets the command that opens an editor on a line of a
+// source. Set it before calling Serve.
+func (c *Coordinator) UseEditorCommand(command string) {
+	c.editorCommand = command
+}
+
+// UseHistory sets how many earlier versions of every in-memory file are kept.
+// Set it before calling Serve.
+func (c *Coordinator) UseHistory(depth int) {
+	c.mem.KeepHistory(depth)
+}
+
+// UseStore keeps the in-memory files on disk so that they are previewed again
+// when the daemon restarts. Set it before calling Serve.
+func (c *Coordinator) UseStore(maxAge time.Duration, maxSize int64) {
+	c.mem.UseStore(sources.NewStore(sources.StorePath(c.port), maxAge, maxSize))
+}
+
+// UseIdleLimits sets how long files and directories are watched after their
+// last browser closes, how long in-memory files are kept after they were last
+// sent while no browser shows them, and how long the daemon keeps running once
+// there is nothing to preview. Zero never gives up on them. Set them before
+// calling Serve.
+func (c *Coordinator) UseIdleLimits(unwatch, forget, exit time.Duration) {
+	for _, source := range c.sources {
+		switch source := source.(type) {
+		case *sources.File:
+			source.ExpireAfter(unwatch)
+		case *sources.Dir:
+			source.ExpireAfter(unwatch)
+		case *sources.Mem:
+			source.ExpireAfter(forget)
+		}
+	}
+	c.exitAfter = exit
 }
 
 // Serve instantiates all the parts required to host the markdown daemon
 func (c *Coordinator) Serve() {
-	dispatcher := dispatch.NewDispatcher()
-	apiServer := server.NewAPI(dispatcher)
-	websocketServer := server.NewWebsocket(dispatcher)
+	dispatcher := c.dispatcher
+	apiServer := server.NewAPI(dispatcher, c.auth)
+	websocketServer := server.NewWebsocket(dispatcher, c.auth)
 	filesServer := server.NewStatic()
+	assetsServer := server.NewAssets(c, c.auth)
+	dashboard := server.NewDashboard(dispatcher, c, c.auth)
+	dispatcher.AddHandler(dashboard)
+	editor := server.NewEditor(c, c.auth, c.editorCommand)
+	dispatcher.AddHandler(editor)
 
-	// Sources of markdown
-	fileSource := sources.NewFile(dispatcher)
-	memSource := sources.NewMem(dispatcher)
-	dispatcher.AddHandler(fileSource)
-	dispatcher.AddHandler(memSource)
-
-	// Track sources
-	c.sources = append(c.sources, fileSource, memSource)
-	dispatcher.AddHandlerFunc(func(r *dispatch.Request) error {
-		if r.Type == "SHUTDOWN" {
+	dispatcher.SubscribeFunc(func(e dispatch.Event) error {
+		if _, ok := e.(*dispatch.ShutdownEvent); ok {
 			log.Printf("coordinator status: waiting for services to shutdown")
 			wg := sync.WaitGroup{}
 			for _, src := range c.sources {
@@ -62,18 +167,38 @@ func (c *Coordinator) Serve() {
 				}()
 			}
 			wg.Wait()
+			server.RemoveSecret(c.port)
 			close(c.done)
 		}
 		return nil
-	})
+	}, dispatch.Shutdown)
 
 	// httpmux handlers
 	apiServer.Serve("/", c.port)
 	websocketServer.Serve("/connect", c.port)
 	filesServer.Serve("/static/")
+	assetsServer.Serve(server.DocumentsPrefix)
+	dashboard.Serve("/dashboard/connect")
+	editor.Serve("/editor/events")
+
+	// bring back the in-memory files of the last run before taking requests
+	c.mem.Restore()
+
+	// stop once nothing has been previewed for a while
+	if c.exitAfter > 0 {
+		dispatcher.SubscribeFunc(func(e dispatch.Event) error {
+			c.exitWhenIdle()
+			return nil
+		}, dispatch.DocChange)
+		c.exitWhenIdle()
+	}
 
 	// special helper endpoint
 	http.HandleFunc("/getid", func(w http.ResponseWriter, r *http.Request) {
+		if !c.auth.Authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 		p := r.FormValue("path")
 		if p == "" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -87,7 +212,100 @@ func (c *Coordinator) Serve() {
 		io.WriteString(w, id)
 	})
 
-	http.Serve(c.listener, nil)
+	// read-only view of what the daemon is doing
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if !c.auth.CanView(w, r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Status())
+	})
+
+	// editors report the line their cursor is on so that previews follow it
+	http.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		if !c.auth.Authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		line, err := strconv.Atoi(r.FormValue("line"))
+		if err != nil || line < 1 {
+			http.Error(w, "line must be a number from 1", http.StatusBadRequest)
+			return
+		}
+		id := c.documentID(r.FormValue("id"))
+		if id == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		done, errCh := c.dispatcher.Dispatch(&dispatch.CursorEvent{ID: id, Line: line})
+		select {
+		case <-done:
+		case err := <-errCh:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	// the markdown of in-memory files, so that it can be exported or diffed
+	http.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		if !c.auth.Authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		version := 0
+		if v := r.FormValue("version"); v != "" {
+			var err error
+			if version, err = strconv.Atoi(v); err != nil || version < 1 {
+				http.Error(w, "version must be a number from 1", http.StatusBadRequest)
+				return
+			}
+		}
+		text, version, err := c.mem.Source(c.documentID(r.FormValue("id")), version)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("X-Godown-Version", strconv.Itoa(version))
+		w.Write(text)
+	})
+
+	http.Serve(c.listener, c.auth.CheckHost(http.DefaultServeMux))
+}
+
+// exitWhenIdle shuts the daemon down once it has had nothing to preview for
+// a while, and keeps it running as long as it has
+func (c *Coordinator) exitWhenIdle() {
+	idle := len(c.Documents()) == 0
+	c.idleLock.Lock()
+	defer c.idleLock.Unlock()
+	if !idle {
+		if c.idle != nil {
+			c.idle.Stop()
+			c.idle = nil
+		}
+		return
+	}
+	if c.idle != nil {
+		return
+	}
+	log.Printf("coordinator status: nothing to preview: exitAfter=%v\n", c.exitAfter)
+	var timer *time.Timer
+	timer = time.AfterFunc(c.exitAfter, func() {
+		c.idleLock.Lock()
+		current := c.idle == timer
+		c.idleLock.Unlock()
+		if !current || len(c.Documents()) > 0 {
+			return
+		}
+		log.Printf("coordinator status: shutting down after having nothing to preview: exitAfter=%v\n", c.exitAfter)
+		c.dispatcher.Dispatch(&dispatch.ShutdownEvent{})
+	})
+	c.idle = timer
 }
 
 // GetID returns the id of a file
@@ -106,6 +324,58 @@ func (c *Coordinator) GetID(path string) string {
 	return ""
 }
 
+// documentID returns the unique id of a document given either its unique id
+// or the path or name it was previewed with
+func (c *Coordinator) documentID(id string) string {
+	if id == "" {
+		return ""
+	}
+	for _, doc := range c.Documents() {
+		if doc.ID == id {
+			return id
+		}
+	}
+	return c.GetID(id)
+}
+
+// AssetRoot returns the directory that a document's relative assets live in
+func (c *Coordinator) AssetRoot(id string) (string, error) {
+	for _, source := range c.sources {
+		if root, err := source.AssetRoot(id); err == nil {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("coordinator warning: no assets for document: id=%q", id)
+}
+
+// Documents lists every document that is being previewed, ordered by name
+func (c *Coordinator) Documents() []server.DocumentInfo {
+	docs := make([]server.DocumentInfo, 0)
+	for _, source := range c.sources {
+		docs = append(docs, source.Documents()...)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].Name != docs[j].Name {
+			return docs[i].Name < docs[j].Name
+		}
+		return docs[i].Source < docs[j].Source
+	})
+	return docs
+}
+
+// Status describes the daemon and every document it is previewing
+func (c *Coordinator) Status() *server.Status {
+	return &server.Status{
+		Running:   true,
+		Version:   Version,
+		Addr:      c.Addr().String(),
+		Port:      c.port,
+		Started:   c.started,
+		Uptime:    time.Since(c.started).Seconds(),
+		Documents: c.Documents(),
+	}
+}
+
 // Wait blocks until server shutdown
 func (c *Coordinator) Wait() {
 	<-c.done
@@ -2,24 +2,25 @@ package dispatch
 
 import "sync"
 
-// Request represents the incoming action a user wants to perform
-type Request struct {
-	Type  string
-	Value interface{}
-}
-
-// A Handler responds to incoming dispatch requests
+// A Handler responds to incoming dispatch events. Each handler receives its
+// events one at a time, in the order they were dispatched.
 type Handler interface {
-	ServeRequest(r *Request) error
+	ServeEvent(e Event) error
 	Wait()
 }
 
-// HandlerFunc is an adapter to allow for functions to be used to receive dispatched requests
-type HandlerFunc func(r *Request) error
+// A Subscriber is a handler that only wants to receive certain types of events
+type Subscriber interface {
+	Handler
+	EventTypes() []EventType
+}
+
+// HandlerFunc is an adapter to allow for functions to be used to receive dispatched events
+type HandlerFunc func(e Event) error
 
-// ServeRequest calls handlerfunc
-func (f HandlerFunc) ServeRequest(r *Request) error {
-	return f(r)
+// ServeEvent calls handlerfunc
+func (f HandlerFunc) ServeEvent(e Event) error {
+	return f(e)
 }
 
 // Wait is noop for handlerfunc
@@ -28,44 +29,80 @@ func (f HandlerFunc) Wait() {}
 // NewDispatcher is the constructor for a new dispatcher
 func NewDispatcher() *Dispatcher {
 	d := &Dispatcher{
-		listeners: make([]Handler, 0),
+		listeners: make([]*subscription, 0),
 	}
 
 	return d
 }
 
-// A Dispatcher is responsible for dispatching requests to handlers
+// A Dispatcher is responsible for dispatching events to handlers
 type Dispatcher struct {
-	listeners []Handler
+	listeners []*subscription
 	sync.Mutex
 }
 
-// AddHandler adds a new handler to the list of request receivers
+// AddHandler adds a new handler to the list of event receivers. Handlers
+// implementing Subscriber only receive the event types they ask for.
 func (d *Dispatcher) AddHandler(h Handler) {
-	d.listeners = append(d.listeners, h)
+	if s, ok := h.(Subscriber); ok {
+		d.Subscribe(h, s.EventTypes()...)
+		return
+	}
+	d.Subscribe(h)
 }
 
-// AddHandlerFunc adds a function to the list of request listeners
+// AddHandlerFunc adds a function to the list of event listeners
 func (d *Dispatcher) AddHandlerFunc(f HandlerFunc) {
 	adapter := HandlerFunc(f)
 	d.AddHandler(adapter)
 }
 
-// Dispatch creates a new Request and dispatches it to all handlers
-func (d *Dispatcher) Dispatch(rType string, rValue interface{}) (chan struct{}, chan error) {
+// Subscribe adds a handler for the given event types. Subscribing without
+// any types receives every event.
+func (d *Dispatcher) Subscribe(h Handler, types ...EventType) {
+	s := &subscription{
+		handler: h,
+		wake:    make(chan struct{}, 1),
+	}
+	if len(types) > 0 {
+		s.types = make(map[EventType]struct{}, len(types))
+		for _, t := range types {
+			s.types[t] = struct{}{}
+		}
+	}
+	go s.run()
+	d.Lock()
+	d.listeners = append(d.listeners, s)
+	d.Unlock()
+}
+
+// SubscribeFunc adds a function as the handler for the given event types
+func (d *Dispatcher) SubscribeFunc(f HandlerFunc, types ...EventType) {
+	d.Subscribe(f, types...)
+}
+
+// Dispatch queues an event for all handlers subscribed to its type. The done
+// channel is closed once every handler has served the event. A handler must
+// not wait on an event it dispatches to itself since it would never be served.
+func (d *Dispatcher) Dispatch(e Event) (chan struct{}, chan error) {
 	var wg sync.WaitGroup
 	doneCh := make(chan struct{})
-	r := &Request{Type: rType, Value: rValue}
 	d.Lock()
 	errorCh := make(chan error, len(d.listeners))
-	for _, h := range d.listeners {
+	for _, s := range d.listeners {
+		if !s.wants(e.EventType()) {
+			continue
+		}
 		wg.Add(1)
-		go func(h Handler) {
-			if err := h.ServeRequest(r); err != nil {
-				errorCh <- err
-			}
-			wg.Done()
-		}(h)
+		s.push(&delivery{
+			event: e,
+			done: func(err error) {
+				if err != nil {
+					errorCh <- err
+				}
+				wg.Done()
+			},
+		})
 	}
 	d.Unlock()
 	go func() {
@@ -74,3 +111,60 @@ func (d *Dispatcher) Dispatch(rType string, rValue interface{}) (chan struct{},
 	}()
 	return doneCh, errorCh
 }
+
+// ----------------------------------------------------------------------------
+// Subscriptions --------------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+// an event waiting to be served along with the callback for its result
+type delivery struct {
+	event Event
+	done  func(error)
+}
+
+// a handler along with the event types it wants (nil for every event) and the
+// queue of events it has yet to serve
+type subscription struct {
+	handler Handler
+	types   map[EventType]struct{}
+	queue   []*delivery
+	wake    chan struct{}
+	sync.Mutex
+}
+
+func (s *subscription) wants(t EventType) bool {
+	if s.types == nil {
+		return true
+	}
+	_, ok := s.types[t]
+	return ok
+}
+
+// push queues an event without blocking the dispatcher
+func (s *subscription) push(d *delivery) {
+	s.Lock()
+	s.queue = append(s.queue, d)
+	s.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run serves the queued events one at a time
+func (s *subscription) run() {
+	for range s.wake {
+		for {
+			s.Lock()
+			if len(s.queue) == 0 {
+				s.Unlock()
+				break
+			}
+			d := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.Unlock()
+			d.done(s.handler.ServeEvent(d.event))
+		}
+	}
+}
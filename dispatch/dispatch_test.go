@@ -0,0 +1,185 @@
+package dispatch
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor fails the test if a channel is not closed in time
+func waitFor(t *testing.T, ch chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestDispatchOrder(t *testing.T) {
+	d := NewDispatcher()
+	var lines []int
+	d.SubscribeFunc(func(e Event) error {
+		lines = append(lines, e.(*CursorEvent).Line)
+		return nil
+	}, Cursor)
+
+	var done chan struct{}
+	for i := 0; i < 1000; i++ {
+		done, _ = d.Dispatch(&CursorEvent{Line: i})
+	}
+	waitFor(t, done, "the last event")
+
+	if len(lines) != 1000 {
+		t.Fatalf("served %d events, want 1000", len(lines))
+	}
+	for i, line := range lines {
+		if line != i {
+			t.Fatalf("event %d served as %d", i, line)
+		}
+	}
+}
+
+func TestDispatchOrderPerSender(t *testing.T) {
+	d := NewDispatcher()
+	const senders, events = 8, 200
+	last := make([]int, senders)
+	for i := range last {
+		last[i] = -1
+	}
+	var errs []string
+	d.SubscribeFunc(func(e Event) error {
+		c := e.(*CursorEvent)
+		sender, _ := strconv.Atoi(c.ID)
+		if c.Line != last[sender]+1 {
+			errs = append(errs, c.ID)
+		}
+		last[sender] = c.Line
+		return nil
+	}, Cursor)
+
+	var wg sync.WaitGroup
+	finished := make(chan chan struct{}, senders)
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			var done chan struct{}
+			for i := 0; i < events; i++ {
+				done, _ = d.Dispatch(&CursorEvent{ID: id, Line: i})
+			}
+			finished <- done
+		}(strconv.Itoa(s))
+	}
+	wg.Wait()
+	close(finished)
+	for done := range finished {
+		waitFor(t, done, "the last event of a sender")
+	}
+
+	if len(errs) > 0 {
+		t.Fatalf("events served out of order for %d senders", len(errs))
+	}
+	for s, line := range last {
+		if line != events-1 {
+			t.Errorf("sender %d: last event %d, want %d", s, line, events-1)
+		}
+	}
+}
+
+func TestSubscribeTypes(t *testing.T) {
+	d := NewDispatcher()
+	var lock sync.Mutex
+	var cursors, everything int
+	d.SubscribeFunc(func(e Event) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if e.EventType() != Cursor {
+			t.Errorf("cursor subscriber got %s", e.EventType())
+		}
+		cursors++
+		return nil
+	}, Cursor)
+	d.AddHandlerFunc(func(e Event) error {
+		lock.Lock()
+		defer lock.Unlock()
+		everything++
+		return nil
+	})
+
+	for _, e := range []Event{&CursorEvent{}, &DocChangeEvent{}, &JumpEvent{}, &CursorEvent{}} {
+		done, _ := d.Dispatch(e)
+		waitFor(t, done, string(e.EventType()))
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if cursors != 2 {
+		t.Errorf("cursor subscriber served %d events, want 2", cursors)
+	}
+	if everything != 4 {
+		t.Errorf("handler without types served %d events, want 4", everything)
+	}
+}
+
+func TestDispatchWithoutSubscribers(t *testing.T) {
+	d := NewDispatcher()
+	d.SubscribeFunc(func(e Event) error { return nil }, Cursor)
+	done, _ := d.Dispatch(&JumpEvent{})
+	waitFor(t, done, "an event nobody subscribed to")
+}
+
+func TestDispatchErrors(t *testing.T) {
+	d := NewDispatcher()
+	failed := errors.New("failed")
+	d.SubscribeFunc(func(e Event) error { return failed }, Cursor)
+	d.SubscribeFunc(func(e Event) error { return nil }, Cursor)
+
+	done, errCh := d.Dispatch(&CursorEvent{})
+	waitFor(t, done, "the event")
+	select {
+	case err := <-errCh:
+		if err != failed {
+			t.Errorf("got error %v, want %v", err, failed)
+		}
+	default:
+		t.Fatal("the error of the handler was not reported")
+	}
+	select {
+	case err := <-errCh:
+		t.Errorf("got a second error %v", err)
+	default:
+	}
+}
+
+func TestSlowHandlerDoesNotBlock(t *testing.T) {
+	d := NewDispatcher()
+	release := make(chan struct{})
+	d.SubscribeFunc(func(e Event) error {
+		<-release
+		return nil
+	}, Cursor)
+	served := make(chan struct{})
+	d.SubscribeFunc(func(e Event) error {
+		close(served)
+		return nil
+	}, Jump)
+
+	// the blocked handler queues its events while the others are served
+	var done chan struct{}
+	for i := 0; i < 100; i++ {
+		done, _ = d.Dispatch(&CursorEvent{Line: i})
+	}
+	d.Dispatch(&JumpEvent{})
+	waitFor(t, served, "the event of another handler")
+
+	select {
+	case <-done:
+		t.Fatal("event served before the handler was released")
+	default:
+	}
+	close(release)
+	waitFor(t, done, "the queued events")
+}
@@ -0,0 +1,205 @@
+package dispatch
+
+import (
+	"fmt"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/davinche/godown/render"
+)
+
+// EventType identifies the kind of an event
+type EventType string
+
+// The event types used by godown itself
+const (
+	FileAdd      EventType = "FILE_ADD"
+	FileDelete   EventType = "FILE_DELETE"
+	FileChange   EventType = "FILE_CHANGE"
+	DirChange    EventType = "DIR_CHANGE"
+	MemAdd       EventType = "MEM_ADD"
+	MemEdit      EventType = "MEM_EDIT"
+	AddClient    EventType = "ADD_WSCLIENT"
+	DelClient    EventType = "DEL_WSCLIENT"
+	ResyncClient EventType = "RESYNC_WSCLIENT"
+	DocChange    EventType = "DOCUMENT_CHANGE"
+	Cursor       EventType = "CURSOR"
+	Jump         EventType = "JUMP"
+	Shutdown     EventType = "SHUTDOWN"
+)
+
+// An Event is a message sent through the dispatcher to its handlers
+type Event interface {
+	EventType() EventType
+}
+
+// FileAddEvent asks for a file or a directory to be previewed
+type FileAddEvent struct {
+	Path     string
+	Renderer string
+	// Root is the previewed directory the file belongs to, if any
+	Root string
+}
+
+// EventType implements Event
+func (e *FileAddEvent) EventType() EventType { return FileAdd }
+
+// FileDeleteEvent stops previewing a file, directory or in-memory file. Path
+// is either the path on disk or the id the in-memory file was sent with.
+type FileDeleteEvent struct {
+	Path string
+}
+
+// EventType implements Event
+func (e *FileDeleteEvent) EventType() EventType { return FileDelete }
+
+// FileChangeEvent reports the new render of a watched file
+type FileChangeEvent struct {
+	Path   string
+	Result *render.Result
+}
+
+// EventType implements Event
+func (e *FileChangeEvent) EventType() EventType { return FileChange }
+
+// DirChangeEvent reports the new index page of a previewed directory
+type DirChangeEvent struct {
+	Path string
+	HTML string
+}
+
+// EventType implements Event
+func (e *DirChangeEvent) EventType() EventType { return DirChange }
+
+// MemAddEvent adds or replaces an in-memory markdown file. History is how many
+// earlier versions of the file to keep, when not nil; otherwise the file keeps
+// the depth it has, or the default one. The new version of the file's text is
+// sent on Reply, when it is not nil, before the event is done.
+type MemAddEvent struct {
+	ID       string
+	Data     []byte
+	Renderer string
+	History  *int
+	Reply    chan<- int
+}
+
+// EventType implements Event
+func (e *MemAddEvent) EventType() EventType { return MemAdd }
+
+// MemEditEvent applies edits, one after the other, to the text of an
+// in-memory markdown file. Base is the version of the text the edits were
+// made to; the new version is sent on Reply, when it is not nil, before the
+// event is done.
+type MemEditEvent struct {
+	ID    string
+	Base  int
+	Edits []Edit
+	Reply chan<- int
+}
+
+// EventType implements Event
+func (e *MemEditEvent) EventType() EventType { return MemEdit }
+
+// Edit replaces a range of text. The range is either Start to End, or Length
+// bytes from Offset when Start is not given.
+type Edit struct {
+	Start  *Position `json:"start,omitempty"`
+	End    *Position `json:"end,omitempty"`
+	Offset int       `json:"offset,omitempty"`
+	Length int       `json:"length,omitempty"`
+	Text   string    `json:"text"`
+}
+
+// Position is a line of text and a byte within it, both counting from 0
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// ConflictError rejects edits made to a version of a file other than the
+// current one; the whole text has to be sent again. A file that is not
+// tracked has version 0.
+type ConflictError struct {
+	ID      string
+	Base    int
+	Version int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("memory error: edits are based on a stale version: id=%q; base=%d; version=%d", e.ID, e.Base, e.Version)
+}
+
+// Client is a browser previewing a document over a websocket
+type Client struct {
+	ID string
+	WS *websocket.Conn
+}
+
+// AddClientEvent reports a newly connected browser
+type AddClientEvent struct {
+	*Client
+}
+
+// EventType implements Event
+func (e *AddClientEvent) EventType() EventType { return AddClient }
+
+// DelClientEvent reports a browser that disconnected
+type DelClientEvent struct {
+	*Client
+}
+
+// EventType implements Event
+func (e *DelClientEvent) EventType() EventType { return DelClient }
+
+// ResyncClientEvent asks for the full render to be sent to a browser
+type ResyncClientEvent struct {
+	*Client
+}
+
+// EventType implements Event
+func (e *ResyncClientEvent) EventType() EventType { return ResyncClient }
+
+// DocChangeEvent reports that a document was added, removed, re-rendered or
+// gained or lost a browser
+type DocChangeEvent struct {
+	ID string
+}
+
+// EventType implements Event
+func (e *DocChangeEvent) EventType() EventType { return DocChange }
+
+// CursorEvent reports the line of a document's source that an editor's cursor
+// is on, counting from 1. ID is the unique id of the document.
+type CursorEvent struct {
+	ID   string
+	Line int
+}
+
+// EventType implements Event
+func (e *CursorEvent) EventType() EventType { return Cursor }
+
+// JumpEvent asks the editors to show a line of a document's source, counting
+// from 1. ID is the unique id of the document.
+type JumpEvent struct {
+	ID   string
+	Line int
+}
+
+// EventType implements Event
+func (e *JumpEvent) EventType() EventType { return Jump }
+
+// ShutdownEvent stops the daemon
+type ShutdownEvent struct{}
+
+// EventType implements Event
+func (e *ShutdownEvent) EventType() EventType { return Shutdown }
+
+// CustomEvent carries an arbitrary payload under a name of the sender's
+// choosing, for extensions that do not want to declare their own event type
+type CustomEvent struct {
+	Name  EventType
+	Value interface{}
+}
+
+// EventType implements Event
+func (e *CustomEvent) EventType() EventType { return e.Name }
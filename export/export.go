@@ -0,0 +1,283 @@
+package export
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/davinche/godown/internal/paths"
+	"github.com/davinche/godown/pages"
+	"github.com/davinche/godown/render"
+)
+
+// Exporter renders markdown files into standalone HTML pages without a
+// running daemon. Images are embedded into the pages as data urls unless
+// CopyAssets is set.
+type Exporter struct {
+	renderers *render.Registry
+	renderer  string
+
+	// CopyAssets copies the images a page refers to next to the page instead
+	// of embedding them
+	CopyAssets bool
+}
+
+// New is the constructor for an exporter. An empty renderer name picks the
+// renderer by file extension.
+func New(renderers *render.Registry, renderer string) *Exporter {
+	return &Exporter{
+		renderers: renderers,
+		renderer:  renderer,
+	}
+}
+
+// Write exports a single markdown file to w. Since there is nowhere to copy
+// them to, images are always embedded.
+func (e *Exporter) Write(w io.Writer, src string) error {
+	p := &page{
+		exporter: e,
+		src:      src,
+		root:     filepath.Dir(src),
+	}
+	return p.write(w)
+}
+
+// File exports a single markdown file to dst
+func (e *Exporter) File(src, dst string) error {
+	p := &page{
+		exporter: e,
+		src:      src,
+		root:     filepath.Dir(src),
+		copied:   make(map[string]struct{}),
+	}
+	if e.CopyAssets {
+		p.dst = filepath.Dir(dst)
+	}
+	return writeFile(dst, p.write)
+}
+
+// Dir exports every markdown file below src into the directory dst, keeping
+// the layout of the files and turning links between them into links between
+// the exported pages. An index page is added unless one of the files already
+// becomes index.html.
+func (e *Exporter) Dir(src, dst string) error {
+	files := e.find(src)
+	copied := make(map[string]struct{})
+	hasIndex := false
+	for _, rel := range files {
+		out := filepath.Join(dst, filepath.FromSlash(pageURL(rel)))
+		if out == filepath.Join(dst, "index.html") {
+			hasIndex = true
+		}
+		p := &page{
+			exporter: e,
+			src:      filepath.Join(src, filepath.FromSlash(rel)),
+			root:     src,
+			dst:      dst,
+			linkable: true,
+			copied:   copied,
+		}
+		log.Printf("export status: exporting file: src=%q; dst=%q\n", p.src, out)
+		if err := writeFile(out, p.write); err != nil {
+			return fmt.Errorf("export error: cannot export file: path=%q; err=%q", p.src, err)
+		}
+	}
+	if hasIndex {
+		return nil
+	}
+
+	index := render.Index(filepath.Base(src), files, pageURL)
+	renderer, err := e.renderers.Select("", "index.md")
+	if err != nil {
+		return err
+	}
+	result, err := renderer.Render(index)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dst, "index.html"), func(w io.Writer) error {
+		return pages.Standalone(w, filepath.Base(src), result.HTML, nil)
+	})
+}
+
+// find returns the slash separated paths of the markdown files below root,
+// skipping hidden directories such as .git
+func (e *Exporter) find(root string) []string {
+	files := make([]string, 0)
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && p != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && e.renderers.Handles(p) {
+			if rel, err := filepath.Rel(root, p); err == nil {
+				files = append(files, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	return files
+}
+
+// ----------------------------------------------------------------------------
+// Page -----------------------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+// page is a single markdown file being exported
+type page struct {
+	exporter *Exporter
+	src      string
+
+	// root is the directory files may be copied from, dst the directory they
+	// are copied to; without dst every image is embedded
+	root string
+	dst  string
+
+	// whether links to other markdown files point to their exported pages
+	linkable bool
+
+	// the files that were already copied to dst
+	copied map[string]struct{}
+}
+
+func (p *page) write(w io.Writer) error {
+	e := p.exporter
+	renderer, err := e.renderers.Select(e.renderer, p.src)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(p.src)
+	if err != nil {
+		return err
+	}
+	result, err := renderer.Render(data)
+	if err != nil {
+		return err
+	}
+
+	title := result.Title
+	if title == "" {
+		title = filepath.Base(p.src)
+	}
+	return pages.Standalone(w, title, render.RewriteURLs(result.HTML, p.rewriteURL), result.Meta)
+}
+
+// rewriteURL points links to other markdown files at their exported pages,
+// and embeds or copies the files that other relative urls refer to
+func (p *page) rewriteURL(attr, u string) string {
+	if !render.IsRelative(u) {
+		return u
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	target := filepath.Join(filepath.Dir(p.src), filepath.FromSlash(parsed.Path))
+	stat, err := os.Stat(target)
+	if err != nil || stat.IsDir() {
+		return u
+	}
+
+	if attr == "href" && p.linkable && p.exporter.renderers.Handles(target) && paths.IsBelow(p.root, target) {
+		link := (&url.URL{Path: pageURL(parsed.Path)}).String()
+		if parsed.Fragment != "" {
+			link += "#" + parsed.Fragment
+		}
+		return link
+	}
+
+	// copy the file when there is somewhere to copy it to so the url keeps working
+	if p.dst != "" && paths.IsBelow(p.root, target) && (attr == "href" || p.exporter.CopyAssets) {
+		if err := p.copy(target); err != nil {
+			log.Printf("export warning: cannot copy asset: path=%q; err=%q\n", target, err)
+		}
+		return u
+	}
+	if attr == "src" {
+		if embedded, err := dataURL(target); err == nil {
+			return embedded
+		}
+		log.Printf("export warning: cannot embed asset: path=%q; err=%q\n", target, err)
+	}
+	return u
+}
+
+// copy copies a file below the root to the same place below dst
+func (p *page) copy(target string) error {
+	if _, ok := p.copied[target]; ok {
+		return nil
+	}
+	p.copied[target] = struct{}{}
+	rel, err := filepath.Rel(p.root, target)
+	if err != nil {
+		return err
+	}
+	out := filepath.Join(p.dst, rel)
+	in, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// exporting next to the markdown files leaves the files where they are
+	inStat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if outStat, err := os.Stat(out); err == nil && os.SameFile(inStat, outStat) {
+		return nil
+	}
+	return writeFile(out, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// ----------------------------------------------------------------------------
+// HELPERS --------------------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+// pageURL returns the slash separated path of the page a markdown file is exported to
+func pageURL(rel string) string {
+	return strings.TrimSuffix(rel, path.Ext(rel)) + ".html"
+}
+
+// dataURL returns a url that contains the whole file
+func dataURL(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(file))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// writeFile creates a file along with its directory and fills it with write
+func writeFile(file string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,25 +1,58 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/davinche/godown/coordinator"
+	"github.com/davinche/godown/export"
+	"github.com/davinche/godown/pages"
+	"github.com/davinche/godown/render"
+	"github.com/davinche/godown/server"
+	"github.com/davinche/godown/sources"
 	"github.com/urfave/cli"
 )
 
 var port int
+var bind string
+var bindSet bool
 var browser string
 var shouldLaunch bool
+var renderer string
+var assetsDir string
+var theme string
+var metaTable bool
+var diagrams cli.StringSlice
+var editorCommand string
+var follow bool
+var history int
+var sendHistory int
+var persist bool
+var persistAge time.Duration
+var persistSize int
+var unwatchAfter time.Duration
+var forgetAfter time.Duration
+var exitAfter time.Duration
+var sourceVersion int
+var frame string
+var jsonOutput bool
+var output string
+var copyAssets bool
 
 var logging string
 var VERSION string
@@ -32,6 +65,7 @@ func main() {
 	app.Name = "godown"
 	app.Usage = "A markdown previewer written in Go"
 	app.Version = VERSION
+	coordinator.Version = VERSION
 	app.Flags = []cli.Flag{
 		cli.IntFlag{
 			Name:        "port, p",
@@ -39,6 +73,12 @@ func main() {
 			Usage:       "the port for the markdown server",
 			Destination: &port,
 		},
+		cli.StringFlag{
+			Name:        "bind",
+			Value:       "127.0.0.1",
+			Usage:       "the address for the markdown server to listen on (ie: 127.0.0.1, ::1, 0.0.0.0); other commands reach a running server on the address it was started with unless this is given",
+			Destination: &bind,
+		},
 		cli.StringFlag{
 			Name:        "browser, b",
 			Value:       "",
@@ -50,6 +90,72 @@ func main() {
 			Usage:       "specify to launch automatically in the browser",
 			Destination: &shouldLaunch,
 		},
+		cli.StringFlag{
+			Name:        "assets-dir",
+			Value:       "",
+			Usage:       "a directory with an index.html and static/ files that override the bundled ones",
+			Destination: &assetsDir,
+		},
+		cli.StringFlag{
+			Name:        "theme",
+			Value:       pages.DefaultTheme,
+			Usage:       "the style code is highlighted in (" + strings.Join(pages.Themes(), ", ") + ")",
+			Destination: &theme,
+		},
+		cli.BoolFlag{
+			Name:        "front-matter-table",
+			Usage:       "show the front matter of a file in a table above its content",
+			Destination: &metaTable,
+		},
+		cli.StringSliceFlag{
+			Name:  "diagram",
+			Usage: "draw a diagram language with a local command that reads the diagram on stdin and writes SVG (ie: dot=\"dot -Tsvg\")",
+			Value: &diagrams,
+		},
+		cli.StringFlag{
+			Name:        "editor-command",
+			Usage:       "the command that opens an editor on a line double-clicked in a preview, with {file}, {line} and {id} replaced (ie: \"code --goto {file}:{line}\")",
+			Value:       "",
+			Destination: &editorCommand,
+		},
+		cli.IntFlag{
+			Name:        "history",
+			Usage:       "how many earlier versions of every in-memory file to keep",
+			Value:       sources.DefaultHistory,
+			Destination: &history,
+		},
+		cli.BoolFlag{
+			Name:        "persist",
+			Usage:       "keep in-memory files on disk and preview them again when the server restarts",
+			Destination: &persist,
+		},
+		cli.DurationFlag{
+			Name:        "persist-max-age",
+			Usage:       "forget kept in-memory files that have not changed for this long (0 for no limit)",
+			Value:       sources.DefaultStoreAge,
+			Destination: &persistAge,
+		},
+		cli.IntFlag{
+			Name:        "persist-max-size",
+			Usage:       "forget the oldest kept in-memory files once they take up more megabytes than this (0 for no limit)",
+			Value:       sources.DefaultStoreSize >> 20,
+			Destination: &persistSize,
+		},
+		cli.DurationFlag{
+			Name:        "unwatch-after",
+			Usage:       "stop watching a file or directory this long after its last browser closes (0 to keep watching)",
+			Destination: &unwatchAfter,
+		},
+		cli.DurationFlag{
+			Name:        "forget-after",
+			Usage:       "forget an in-memory file this long after it was last sent, once no browser shows it (0 to keep it)",
+			Destination: &forgetAfter,
+		},
+		cli.DurationFlag{
+			Name:        "exit-after",
+			Usage:       "stop the markdown server once it has had nothing to preview for this long (0 to keep running)",
+			Destination: &exitAfter,
+		},
 		cli.StringFlag{
 			Name:        "logging",
 			Usage:       "specify logging output (stdout, stderr)",
@@ -62,12 +168,26 @@ func main() {
 	// Commands ---------------------------------------------------------------
 	// ------------------------------------------------------------------------
 
+	rendererFlag := cli.StringFlag{
+		Name:        "renderer, r",
+		Usage:       "the renderer to convert the markdown with (defaults to the file extension's renderer)",
+		Value:       "",
+		Destination: &renderer,
+	}
+
+	jsonFlag := cli.BoolFlag{
+		Name:        "json",
+		Usage:       "print the output as JSON",
+		Destination: &jsonOutput,
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:      "start",
-			Usage:     "preview a file at a given path",
-			ArgsUsage: "<FILEPATH>",
+			Usage:     "preview a file or a directory of files at a given path",
+			ArgsUsage: "<FILEPATH|DIRECTORY>",
 			Action:    start,
+			Flags:     []cli.Flag{rendererFlag},
 		},
 		{
 			Name:      "stop",
@@ -80,11 +200,94 @@ func main() {
 			Usage:     "sends data from stdin to the markdown server",
 			ArgsUsage: "<ID>",
 			Action:    send,
+			Flags: []cli.Flag{
+				rendererFlag,
+				cli.BoolFlag{
+					Name:        "follow, f",
+					Usage:       "keep reading documents from stdin and preview each one as it arrives, printing \"ok VERSION\" or \"stale VERSION\" for each",
+					Destination: &follow,
+				},
+				cli.StringFlag{
+					Name:        "frame",
+					Usage:       "how the followed documents are separated (nul: by NUL bytes, json: one {\"text\": ...} or {\"base\": VERSION, \"edits\": [...]} object per line)",
+					Value:       server.FrameNUL,
+					Destination: &frame,
+				},
+				cli.IntFlag{
+					Name:        "history",
+					Usage:       "how many earlier versions of this file to keep (defaults to the server's --history)",
+					Destination: &sendHistory,
+				},
+			},
+		},
+		{
+			Name:      "export",
+			Aliases:   []string{"render"},
+			Usage:     "renders a file or a directory of files into standalone HTML without a server",
+			ArgsUsage: "<FILEPATH|DIRECTORY>",
+			Action:    exportFiles,
+			Flags: []cli.Flag{
+				rendererFlag,
+				cli.StringFlag{
+					Name:        "output, o",
+					Usage:       "the file, or the directory for a directory of files, to write to (defaults to stdout for a file)",
+					Value:       "",
+					Destination: &output,
+				},
+				cli.BoolFlag{
+					Name:        "copy-assets",
+					Usage:       "copy images next to the output instead of embedding them",
+					Destination: &copyAssets,
+				},
+			},
+		},
+		{
+			Name:      "cursor",
+			Usage:     "scrolls the previews of a file or an ID to a line of its source",
+			ArgsUsage: "<FILEPATH|ID> <LINE>",
+			Action:    cursor,
+		},
+		{
+			Name:      "source",
+			Usage:     "prints the markdown last sent to an ID, or an earlier version of it",
+			ArgsUsage: "<ID>",
+			Action:    source,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:        "version",
+					Usage:       "the version to print (defaults to the current one)",
+					Destination: &sourceVersion,
+				},
+			},
+		},
+		{
+			Name:      "listen",
+			Usage:     "prints the lines double-clicked in the previews of a file, or of every document, as JSON",
+			ArgsUsage: "[FILEPATH|ID]",
+			Action:    listen,
+		},
+		{
+			Name:   "list",
+			Usage:  "lists the documents the markdown server is previewing",
+			Action: list,
+			Flags:  []cli.Flag{jsonFlag},
+		},
+		{
+			Name:   "status",
+			Usage:  "shows whether the markdown server is running and what it is doing",
+			Action: status,
+			Flags:  []cli.Flag{jsonFlag},
+		},
+		{
+			Name:   "dashboard",
+			Usage:  "opens the page listing every preview in the browser",
+			Action: dashboard,
 		},
 	}
 
 	// See what kind of logging to do
 	app.Before = func(c *cli.Context) error {
+		bindSet = c.IsSet("bind")
 		switch strings.ToLower(logging) {
 		case "stdout":
 			log.SetOutput(os.Stdout)
@@ -112,13 +315,21 @@ func start(c *cli.Context) (ret error) {
 		return
 	}
 
+	// the daemon may be running from a different working directory
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+
 	log.Printf("start command: port=%d; shouldLaunch=%v, browser=%q; file=%q",
 		port, shouldLaunch, browser, file)
 
 	// See if we need to start the daemon
-	coordinator, err := coordinator.New(port)
+	useAssets()
+	coordinator, err := coordinator.New(bind, port)
 	if err == nil {
 		// start the daemon
+		warnPublic(coordinator)
+		useDaemonOptions(coordinator)
 		go coordinator.Serve()
 		addFile(file)
 		if shouldLaunch {
@@ -152,6 +363,13 @@ func stop(c *cli.Context) (ret error) {
 		killServer()
 		return
 	}
+
+	// stop accepts both file paths and in-memory ids
+	if _, err := os.Stat(file); err == nil {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+	}
 	killFile(file)
 	return
 }
@@ -164,7 +382,14 @@ func send(c *cli.Context) (ret error) {
 		cli.ShowSubcommandHelp(c)
 		return
 	}
-	log.Printf("send command: port=%d; shouldLaunch=%v\n", port, shouldLaunch)
+	if !c.IsSet("history") {
+		sendHistory = -1
+	}
+	log.Printf("send command: port=%d; shouldLaunch=%v; follow=%v; history=%d\n", port, shouldLaunch, follow, sendHistory)
+	if follow {
+		followData(file)
+		return
+	}
 	data, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
 		log.Fatalf("error: could not read markdown data: error=%q\n", err)
@@ -172,9 +397,12 @@ func send(c *cli.Context) (ret error) {
 	log.Printf("send command: read data: data=%q\n", string(data))
 
 	// See if we need to start the daemon
-	coordinator, err := coordinator.New(port)
+	useAssets()
+	coordinator, err := coordinator.New(bind, port)
 	if err == nil {
 		// start the daemon
+		warnPublic(coordinator)
+		useDaemonOptions(coordinator)
 		go coordinator.Serve()
 		addData(file, data)
 		if shouldLaunch {
@@ -202,11 +430,262 @@ func send(c *cli.Context) (ret error) {
 	return
 }
 
+// followData previews every document streamed on stdin, starting the daemon
+// if it is not running yet
+func followData(file string) {
+	useAssets()
+	coordinator, err := coordinator.New(bind, port)
+	if err == nil {
+		// start the daemon, which keeps serving the last document after the
+		// stream ends
+		warnPublic(coordinator)
+		useDaemonOptions(coordinator)
+		go coordinator.Serve()
+		if err := streamData(file, func() { launchBrowser(coordinator.GetID(file)) }); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		coordinator.Wait()
+		return
+	}
+	if err := streamData(file, func() { launchBrowser(getID(file)) }); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func cursor(c *cli.Context) (ret error) {
+	ret = nil
+	target := c.Args().First()
+	if target == "" || c.Args().Get(1) == "" {
+		cli.ShowSubcommandHelp(c)
+		return
+	}
+	line, err := strconv.Atoi(c.Args().Get(1))
+	if err != nil || line < 1 {
+		fmt.Fprintf(os.Stderr, "error: not a line number: %q\n", c.Args().Get(1))
+		os.Exit(1)
+	}
+
+	// files are previewed by their absolute path; anything else is an ID
+	if _, err := os.Stat(target); err == nil {
+		if abs, err := filepath.Abs(target); err == nil {
+			target = abs
+		}
+	}
+	log.Printf("cursor command: target=%q; line=%d\n", target, line)
+	moveCursor(target, line)
+	return
+}
+
+func source(c *cli.Context) (ret error) {
+	ret = nil
+	id := c.Args().First()
+	if id == "" {
+		cli.ShowSubcommandHelp(c)
+		return
+	}
+	log.Printf("source command: id=%q; version=%d\n", id, sourceVersion)
+	os.Stdout.Write(getSource(id, sourceVersion))
+	return
+}
+
+func listen(c *cli.Context) (ret error) {
+	ret = nil
+	target := c.Args().First()
+	if _, err := os.Stat(target); target != "" && err == nil {
+		if abs, err := filepath.Abs(target); err == nil {
+			target = abs
+		}
+	}
+	log.Printf("listen command: port=%d; target=%q\n", port, target)
+	listenJumps(target)
+	return
+}
+
+func dashboard(c *cli.Context) (ret error) {
+	ret = nil
+	log.Printf("dashboard command: port=%d\n", port)
+	if _, err := server.ReadSecret(port); err != nil {
+		log.Fatalf("error: markdown server is not running: port=%d; error=%q\n", port, err)
+	}
+	openBrowser("/")
+	return
+}
+
+func exportFiles(c *cli.Context) (ret error) {
+	ret = nil
+	file := c.Args().First()
+	if file == "" {
+		cli.ShowSubcommandHelp(c)
+		return
+	}
+	log.Printf("export command: file=%q; output=%q; copyAssets=%v\n", file, output, copyAssets)
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		log.Fatalf("error: cannot get absolute path: error=%q\n", err)
+	}
+	stat, err := os.Stat(abs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	useAssets()
+	renderers := render.NewRegistry()
+	useRenderers(renderers)
+	exporter := export.New(renderers, renderer)
+	exporter.CopyAssets = copyAssets
+	switch {
+	case stat.IsDir() && output == "":
+		fmt.Fprintln(os.Stderr, "error: exporting a directory needs an output directory (--output)")
+		os.Exit(1)
+	case stat.IsDir():
+		err = exporter.Dir(abs, output)
+	case output == "":
+		err = exporter.Write(os.Stdout, abs)
+	default:
+		err = exporter.File(abs, output)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: could not export: %v\n", err)
+		os.Exit(1)
+	}
+	return
+}
+
+func list(c *cli.Context) (ret error) {
+	ret = nil
+	log.Printf("list command: port=%d; json=%v\n", port, jsonOutput)
+	st, err := getStatus()
+	if err != nil {
+		if jsonOutput {
+			printJSON([]server.DocumentInfo{})
+		} else {
+			fmt.Fprintf(os.Stderr, "godown is not running on port %d\n", port)
+		}
+		os.Exit(1)
+	}
+	if jsonOutput {
+		printJSON(st.Documents)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSOURCE\tBROWSERS\tUPDATED\tTITLE\tNAME")
+	for _, doc := range st.Documents {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			doc.ID, doc.Source, doc.Clients, doc.Updated.Format("15:04:05"), doc.Title, doc.Name)
+	}
+	w.Flush()
+	return
+}
+
+func status(c *cli.Context) (ret error) {
+	ret = nil
+	log.Printf("status command: port=%d; json=%v\n", port, jsonOutput)
+	st, err := getStatus()
+	if err != nil {
+		if jsonOutput {
+			printJSON(&server.Status{Port: port, Documents: []server.DocumentInfo{}})
+		} else {
+			fmt.Printf("godown is not running on port %d\n", port)
+		}
+		os.Exit(1)
+	}
+	if jsonOutput {
+		printJSON(st)
+		return
+	}
+
+	clients := 0
+	for _, doc := range st.Documents {
+		clients += doc.Clients
+	}
+	version := st.Version
+	if version == "" {
+		version = "unknown"
+	}
+	uptime := time.Duration(st.Uptime) * time.Second
+	fmt.Printf("godown %s is running on %s\n", version, st.Addr)
+	fmt.Printf("uptime: %s\n", uptime)
+	fmt.Printf("documents: %d\n", len(st.Documents))
+	fmt.Printf("browsers: %d\n", clients)
+	return
+}
+
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatalf("error: could not encode output: error=%q\n", err)
+	}
+}
+
+// warnPublic lets the user know when previews are reachable from other machines
+func warnPublic(c *coordinator.Coordinator) {
+	if c.Public() {
+		fmt.Fprintf(os.Stderr, "warning: godown is listening on %s which is reachable from other machines; "+
+			"anyone on the network can read your previews and stop the server\n", c.Addr())
+	}
+}
+
+// useAssets applies the page and style customizations
+func useAssets() {
+	if assetsDir != "" {
+		if err := pages.UseAssetsDir(assetsDir); err != nil {
+			fmt.Fprintf(os.Stderr, "error: could not use assets directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := pages.UseTheme(theme); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// useDaemonOptions applies the options of the daemon to a coordinator that
+// has not started serving yet
+func useDaemonOptions(c *coordinator.Coordinator) {
+	useRenderers(c.Renderers())
+	c.UseEditorCommand(editorCommand)
+	c.UseHistory(history)
+	c.UseIdleLimits(unwatchAfter, forgetAfter, exitAfter)
+	if persist {
+		c.UseStore(persistAge, int64(persistSize)<<20)
+	}
+}
+
+// useRenderers applies the rendering options to a set of renderers
+func useRenderers(renderers *render.Registry) {
+	commands := make(render.DiagramCommands)
+	for _, setting := range diagrams.Value() {
+		lang, command, err := render.ParseDiagramCommand(setting)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		commands[lang] = command
+	}
+	// mermaid fences are only left for the browser when it can draw them
+	assets, _ := pages.Assets()
+	renderers.Register(render.DefaultName, &render.GFM{
+		MetaTable: metaTable,
+		Diagrams:  commands,
+		Mermaid:   pages.HasMermaid(assets),
+	})
+}
+
 // ----------------------------------------------------------------------------
 // Launch Browser Helper-------------------------------------------------------
 // ----------------------------------------------------------------------------
 
 func launchBrowser(id string) {
+	openBrowser("/?id=" + id)
+}
+
+// openBrowser opens a page of the markdown server, handing the browser the
+// token that allows it to view previews
+func openBrowser(path string) {
 	// Launch the browser
 	var args []string
 	if browser == "" {
@@ -228,7 +707,16 @@ func launchBrowser(id string) {
 	if len(args) == 0 {
 		log.Println("error: could not determine how to launch browser")
 	}
-	args = append(args, "http://localhost:"+strconv.Itoa(port)+"?id="+id)
+	previewURL := serverURL(path)
+	if secret, err := server.ReadSecret(port); err == nil {
+		if strings.Contains(path, "?") {
+			previewURL += "&"
+		} else {
+			previewURL += "?"
+		}
+		previewURL += "token=" + server.ViewToken(secret)
+	}
+	args = append(args, previewURL)
 	log.Printf("launch browser cmd: args=%v\n", args)
 	command := exec.Command(args[0], args[1:]...)
 	err := command.Start()
@@ -240,79 +728,263 @@ func launchBrowser(id string) {
 // ----------------------------------------------------------------------------
 // HTTP API Helpers -----------------------------------------------------------
 // ----------------------------------------------------------------------------
+
+// serverURL returns the url of a path on the markdown server
+func serverURL(path string) string {
+	return "http://" + net.JoinHostPort(coordinator.ClientHost(serverBind()), strconv.Itoa(port)) + path
+}
+
+// serverBind returns the address the markdown server listens on: the one it
+// was started with, unless --bind is given
+func serverBind() string {
+	if !bindSet {
+		if addr, err := server.ReadBind(port); err == nil {
+			return addr
+		}
+	}
+	return bind
+}
+
+// authorize adds the daemon's session secret to a request
+func authorize(req *http.Request) {
+	secret, err := server.ReadSecret(port)
+	if err != nil {
+		log.Printf("error: could not read session secret: error=%q\n", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+}
+
+// expectOK exits when a request to the markdown server did not succeed
+func expectOK(res *http.Response, err error, action string) {
+	if err != nil {
+		log.Fatalf("error: could not %s: error=%q\n", action, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		log.Fatalf("error: could not %s: statusCode=%d\n", action, res.StatusCode)
+	}
+}
+
 func addFile(filePath string) {
 	client := http.Client{}
-	marshalled, err := json.Marshal(&struct{ Path string }{filePath})
+	marshalled, err := json.Marshal(&server.FileRequest{Path: filePath, Renderer: renderer})
 	if err != nil {
 		log.Fatalf("error: could not marshal filePath: error=%q\n", err)
 	}
-	req, err := http.NewRequest("POST", "http://localhost:"+strconv.Itoa(port), bytes.NewBuffer(marshalled))
+	req, err := http.NewRequest("POST", serverURL("/"), bytes.NewBuffer(marshalled))
 	if err != nil {
 		log.Fatalf("error: could create http request: error=%q\n", err)
 	}
+	authorize(req)
 	res, err := client.Do(req)
-	if err != nil || res.StatusCode != http.StatusOK {
-		log.Fatalf("error: could not preview markdown file: err=%q; statusCode=%q\n", err, res.StatusCode)
-	}
+	expectOK(res, err, "preview markdown file")
 }
 
 func getID(filePath string) string {
 	client := http.Client{}
-	req, err := http.NewRequest("GET", "http://localhost:"+strconv.Itoa(port)+"/getid?path="+filePath, nil)
+	req, err := http.NewRequest("GET", serverURL("/getid?path="+url.QueryEscape(filePath)), nil)
 	if err != nil {
 		log.Fatalf("error: could create http getID request: error=%q\n", err)
 	}
+	authorize(req)
 	res, err := client.Do(req)
-	if err != nil || res.StatusCode != http.StatusOK {
-		log.Fatalf("error: could not get ID of the file: err=%q; statusCode=%q\n", err, res.StatusCode)
-	}
+	expectOK(res, err, "get ID of the file")
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		log.Fatalf("error: could not get ID of the file from body: err=%q\n")
+		log.Fatalf("error: could not get ID of the file from body: err=%q\n", err)
 	}
 	return string(data)
 }
 
+// getStatus asks the markdown server what it is doing. It gives up quickly so
+// that shell prompts are not held up by a stuck server.
+func getStatus() (*server.Status, error) {
+	client := http.Client{Timeout: 2 * time.Second}
+	req, err := http.NewRequest("GET", serverURL("/status"), nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: statusCode=%d", res.StatusCode)
+	}
+	st := &server.Status{}
+	if err := json.NewDecoder(res.Body).Decode(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// sendURL returns the URL markdown is sent to, followed in frames of a format
+// unless it is empty
+func sendURL(id, format string) string {
+	path := "/?id=" + url.QueryEscape(id) + "&renderer=" + url.QueryEscape(renderer)
+	if sendHistory >= 0 {
+		path += "&history=" + strconv.Itoa(sendHistory)
+	}
+	if format != "" {
+		path += "&follow=" + url.QueryEscape(format)
+	}
+	return serverURL(path)
+}
+
 func addData(id string, data []byte) {
 	fmt.Println("in ADD DAata")
 	fmt.Println(id)
 	client := http.Client{}
 	req, err := http.NewRequest(
 		"PUT",
-		"http://localhost:"+strconv.Itoa(port)+"?id="+id,
+		sendURL(id, ""),
 		bytes.NewBuffer(data),
 	)
 
 	if err != nil {
 		log.Fatalf("error: could not create PUT request: error=%q\n", err)
 	}
+	authorize(req)
+	res, err := client.Do(req)
+	expectOK(res, err, "send data to markdown server")
+}
+
+// streamData sends stdin to the markdown server over one request. The server
+// answers every frame with a line that is printed to stdout, and the browser
+// is launched once the first document is ready.
+func streamData(id string, launch func()) error {
+	client := http.Client{}
+	req, err := http.NewRequest(
+		"PUT",
+		sendURL(id, frame),
+		os.Stdin,
+	)
+	if err != nil {
+		log.Fatalf("error: could not create PUT request: error=%q\n", err)
+	}
+	authorize(req)
+	res, err := client.Do(req)
+	if err == nil && res.StatusCode == http.StatusBadRequest {
+		message, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s", strings.TrimSpace(string(message)))
+	}
+	expectOK(res, err, "stream data to markdown server")
+	defer res.Body.Close()
+
+	// editors read the versions their edits are based on from stdout
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "error: ") {
+			return fmt.Errorf("%s", strings.TrimPrefix(line, "error: "))
+		}
+		fmt.Println(line)
+		if shouldLaunch && launch != nil && strings.HasPrefix(line, "ok ") {
+			launch()
+			launch = nil
+		}
+	}
+	return scanner.Err()
+}
+
+// getSource fetches the markdown of a version of an in-memory file, or of its
+// current version when version is 0
+func getSource(id string, version int) []byte {
+	client := http.Client{}
+	query := "/source?id=" + url.QueryEscape(id)
+	if version != 0 {
+		query += "&version=" + strconv.Itoa(version)
+	}
+	req, err := http.NewRequest("GET", serverURL(query), nil)
+	if err != nil {
+		log.Fatalf("error: could not create source request: error=%q\n", err)
+	}
+	authorize(req)
+	res, err := client.Do(req)
+	if err == nil && res.StatusCode == http.StatusNotFound {
+		fmt.Fprintf(os.Stderr, "error: no such version being previewed: %s\n", id)
+		os.Exit(1)
+	}
+	expectOK(res, err, "get the source")
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Fatalf("error: could not read the source: err=%q\n", err)
+	}
+	return data
+}
+
+func moveCursor(id string, line int) {
+	client := http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		serverURL("/cursor?id="+url.QueryEscape(id)+"&line="+strconv.Itoa(line)),
+		nil,
+	)
+	if err != nil {
+		log.Fatalf("error: could not create cursor request: error=%q\n", err)
+	}
+	authorize(req)
 	res, err := client.Do(req)
-	if err != nil || res.StatusCode != http.StatusOK {
-		log.Fatalf("error: could not send data to markdown server: error=%q; statusCode=%q\n", err, res.StatusCode)
+	if err == nil && res.StatusCode == http.StatusNotFound {
+		fmt.Fprintf(os.Stderr, "error: not being previewed: %s\n", id)
+		os.Exit(1)
+	}
+	expectOK(res, err, "move the cursor")
+}
+
+// listenJumps prints the jumps to the lines of a document, or of every
+// document when the target is empty, until the server stops
+func listenJumps(target string) {
+	client := http.Client{}
+	req, err := http.NewRequest("GET", serverURL("/editor/events"), nil)
+	if err != nil {
+		log.Fatalf("error: could not create listen request: error=%q\n", err)
+	}
+	authorize(req)
+	res, err := client.Do(req)
+	expectOK(res, err, "listen for jumps")
+	defer res.Body.Close()
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		var jump server.Jump
+		if err := json.Unmarshal([]byte(data), &jump); err != nil {
+			log.Printf("listen warning: malformed jump: data=%q\n", data)
+			continue
+		}
+		if target == "" || target == jump.Name || target == jump.ID {
+			fmt.Println(data)
+		}
 	}
 }
 
 func killServer() {
 	client := http.Client{}
-	req, err := http.NewRequest("DELETE", "http://localhost:"+strconv.Itoa(port), nil)
+	req, err := http.NewRequest("DELETE", serverURL("/"), nil)
 	if err != nil {
 		log.Fatalf("error: could not create shutdown request: error=%q\n", err)
 	}
+	authorize(req)
 	res, err := client.Do(req)
-	if err != nil || res.StatusCode != http.StatusOK {
-		log.Fatalf("error: could not shutdown server: error=%q; statusCode=%q\n", err, res.StatusCode)
-	}
+	expectOK(res, err, "shutdown server")
 }
 
 func killFile(file string) {
 	client := http.Client{}
-	req, err := http.NewRequest("DELETE", "http://localhost:"+strconv.Itoa(port)+"?id="+file, nil)
+	req, err := http.NewRequest("DELETE", serverURL("/?id="+url.QueryEscape(file)), nil)
 	if err != nil {
 		log.Fatalf("error: could not create delete file request: error=%q\n", err)
 	}
+	authorize(req)
 	res, err := client.Do(req)
-	if err != nil || res.StatusCode != http.StatusOK {
-		log.Fatalf("error: could not delete file: error=%q; statusCode=%q\n", err, res.StatusCode)
-	}
+	expectOK(res, err, "delete file")
 }
@@ -0,0 +1,16 @@
+// Package paths holds the file path helpers shared by the sources, the
+// servers and the exporter.
+package paths
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// IsBelow reports whether path is inside of the root directory, or is the
+// root itself. Both paths are expected to be clean and absolute, or relative
+// to the same directory.
+func IsBelow(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
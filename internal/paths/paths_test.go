@@ -0,0 +1,30 @@
+package paths
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIsBelow(t *testing.T) {
+	tests := []struct {
+		root, path string
+		want       bool
+	}{
+		{"/a", "/a", true},
+		{"/a", "/a/b.md", true},
+		{"/a", "/a/b/c.md", true},
+		{"/a", "/a/../a/b.md", true},
+		{"/a", "/ab.md", false},
+		{"/a", "/a/../b.md", false},
+		{"/a/b", "/a", false},
+		{"/a", "/a/..b.md", true},
+		{"a", "a/b.md", true},
+		{"a", "/a/b.md", false},
+	}
+	for _, test := range tests {
+		root, path := filepath.FromSlash(test.root), filepath.FromSlash(test.path)
+		if got := IsBelow(root, path); got != test.want {
+			t.Errorf("IsBelow(%q, %q) = %v, want %v", root, path, got, test.want)
+		}
+	}
+}
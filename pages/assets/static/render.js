@@ -0,0 +1,68 @@
+// Finishes rendering the math and diagrams of a preview in the browser. The
+// libraries are optional: without KaTeX math is left as its TeX source, and
+// without mermaid diagrams are left as their source.
+(function() {
+  var diagrams = 0;
+
+  if (window.mermaid) {
+    mermaid.initialize({startOnLoad: false});
+  }
+
+  function find(node, selector) {
+    var found = Array.prototype.slice.call(node.querySelectorAll(selector));
+    if (node.matches && node.matches(selector)) {
+      found.push(node);
+    }
+    return found;
+  }
+
+  // typesets the math below a node
+  function typeset(node) {
+    if (!window.katex) {
+      return;
+    }
+    find(node, '.math').forEach(function(el) {
+      katex.render(el.textContent, el, {displayMode: el.classList.contains('math-display'), throwOnError: false});
+    });
+  }
+
+  // shows why a diagram could not be drawn above its source
+  function fail(el, source, err) {
+    var error = document.createElement('pre');
+    error.className = 'diagram-error';
+    error.textContent = String((err && err.message) || err);
+    el.insertBefore(error, source);
+  }
+
+  // draws the mermaid diagrams below a node. Only new blocks are passed in, so
+  // diagrams that did not change are not drawn again.
+  function draw(node) {
+    if (!window.mermaid) {
+      return;
+    }
+    find(node, '.diagram-mermaid').forEach(function(el) {
+      var source = el.querySelector('.diagram-source');
+      if (!source) {
+        return;
+      }
+      var id = 'godown-diagram-' + (++diagrams);
+      mermaid.render(id, source.textContent).then(function(result) {
+        el.innerHTML = result.svg;
+      }, function(err) {
+        // mermaid leaves its own error drawing behind in the page
+        var leftover = document.getElementById('d' + id);
+        if (leftover) {
+          leftover.parentNode.removeChild(leftover);
+        }
+        fail(el, source, err);
+      });
+    });
+  }
+
+  window.godown = {
+    render: function(node) {
+      typeset(node);
+      draw(node);
+    }
+  };
+})();
@@ -0,0 +1,16 @@
+package pages
+
+import "io/fs"
+
+// the script that draws math and diagrams once a page has loaded
+const renderScript = "static/render.js"
+
+// Mermaid draws diagrams in the browser. Like KaTeX, `make assets` copies the
+// release pinned in package.json into static/mermaid. Without it mermaid
+// fences are rendered as plain code blocks.
+const mermaidScript = "static/mermaid/mermaid.min.js"
+
+// HasMermaid reports whether mermaid diagrams can be drawn
+func HasMermaid(assets fs.FS) bool {
+	return hasAssets(assets, mermaidScript)
+}
@@ -0,0 +1,94 @@
+// Package pages holds the page templates and the stylesheets and scripts they
+// link to, which the daemon serves and exported pages inline.
+package pages
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// the page templates and the stylesheets/scripts they link to are compiled into
+// the binary so that a single file can serve previews anywhere
+//
+//go:embed assets
+var embedded embed.FS
+
+// the page templates served by the daemon
+var pages = []string{"index.html", "dashboard.html", "export.html"}
+
+var (
+	assetsMu  sync.RWMutex
+	assets    fs.FS = mustSub(embedded, "assets")
+	templates       = template.Must(template.ParseFS(assets, pages...))
+)
+
+// UseAssetsDir lets files in a directory override the bundled assets. The
+// directory mirrors the bundled layout: the pages at the top and stylesheets
+// and scripts in static/. Anything missing from the directory falls back to
+// the bundled copy.
+func UseAssetsDir(dir string) error {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("assets error: not a directory: path=%q", dir)
+	}
+
+	fsys := &overlayFS{
+		over:  os.DirFS(dir),
+		under: mustSub(embedded, "assets"),
+	}
+	t, err := template.ParseFS(fsys, pages...)
+	if err != nil {
+		return err
+	}
+
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+	assets = fsys
+	templates = t
+	return nil
+}
+
+// hasAssets reports whether every one of the files is available
+func hasAssets(assets fs.FS, names ...string) bool {
+	for _, name := range names {
+		if _, err := fs.Stat(assets, name); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Assets returns the assets pages are made from and the page templates
+func Assets() (fs.FS, *template.Template) {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	return assets, templates
+}
+
+// overlayFS serves files from over when they exist and from under otherwise
+type overlayFS struct {
+	over  fs.FS
+	under fs.FS
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	if f, err := o.over.Open(name); err == nil {
+		return f, nil
+	}
+	return o.under.Open(name)
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,54 @@
+package pages
+
+import (
+	"encoding/base64"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// KaTeX typesets the math of a page. `make assets` copies the release pinned
+// in package.json out of node_modules into static/katex, where it is compiled
+// into the binary; an assets directory can provide it as well. Without it math
+// is shown as its TeX source.
+const (
+	katexScript = "static/katex/katex.min.js"
+	katexStyle  = "static/katex/katex.min.css"
+)
+
+// the fonts of the KaTeX stylesheet, and the larger formats that browsers
+// only fall back to
+var (
+	katexFont         = regexp.MustCompile(`url\((fonts/[^)]+)\)`)
+	katexFallbackFont = regexp.MustCompile(`,url\(fonts/[^)]+\) format\("(woff|truetype)"\)`)
+)
+
+// HasKaTeX reports whether math can be typeset
+func HasKaTeX(assets fs.FS) bool {
+	return hasAssets(assets, katexScript, katexStyle)
+}
+
+// inlineKaTeX returns the KaTeX stylesheet, with its fonts embedded, and script
+func inlineKaTeX(assets fs.FS) (string, string, error) {
+	script, err := fs.ReadFile(assets, katexScript)
+	if err != nil {
+		return "", "", err
+	}
+	style, err := fs.ReadFile(assets, katexStyle)
+	if err != nil {
+		return "", "", err
+	}
+
+	style = katexFallbackFont.ReplaceAll(style, nil)
+	style = katexFont.ReplaceAllFunc(style, func(match []byte) []byte {
+		font := string(katexFont.FindSubmatch(match)[1])
+		data, err := fs.ReadFile(assets, path.Join(path.Dir(katexStyle), font))
+		if err != nil {
+			return match
+		}
+		format := strings.TrimPrefix(path.Ext(font), ".")
+		return []byte("url(data:font/" + format + ";base64," + base64.StdEncoding.EncodeToString(data) + ")")
+	})
+	return string(style), string(script), nil
+}
@@ -0,0 +1,69 @@
+package pages
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/davinche/godown/render"
+)
+
+// Standalone writes a page around a rendered markdown fragment that needs
+// nothing but itself to be viewed: the stylesheets are inlined into the page.
+// The description and tags of the metadata are shown above the content.
+func Standalone(w io.Writer, title, fragment string, meta map[string]interface{}) error {
+	assets, templates := Assets()
+	var css bytes.Buffer
+	for _, name := range []string{"static/github-markdown.css", themePath(Theme())} {
+		data, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return err
+		}
+		css.Write(data)
+		css.WriteString("\n")
+	}
+
+	// only pages with math or diagrams carry the weight of the libraries
+	// that draw them
+	scripts := make([]template.JS, 0)
+	if HasKaTeX(assets) && strings.Contains(fragment, `class="math `) {
+		style, js, err := inlineKaTeX(assets)
+		if err != nil {
+			return err
+		}
+		css.WriteString(style)
+		scripts = append(scripts, template.JS(js))
+	}
+	if HasMermaid(assets) && strings.Contains(fragment, `class="diagram diagram-mermaid"`) {
+		js, err := fs.ReadFile(assets, mermaidScript)
+		if err != nil {
+			return err
+		}
+		scripts = append(scripts, template.JS(js))
+	}
+	if len(scripts) > 0 {
+		js, err := fs.ReadFile(assets, renderScript)
+		if err != nil {
+			return err
+		}
+		scripts = append(scripts, template.JS(js))
+	}
+
+	return templates.ExecuteTemplate(w, "export.html", struct {
+		Title       string
+		Description string
+		Tags        []string
+		CSS         template.CSS
+		Scripts     []template.JS
+		Body        template.HTML
+	}{
+		title,
+		render.MetaString(meta, "description"),
+		render.MetaList(meta, "tags"),
+		template.CSS(css.String()),
+		scripts,
+		template.HTML(fragment),
+	})
+}
@@ -0,0 +1,51 @@
+package pages
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// DefaultTheme is the style code is highlighted in unless another is picked
+const DefaultTheme = "github"
+
+var theme = DefaultTheme
+
+// UseTheme picks the style code is highlighted in. Themes are the stylesheets
+// in static/themes/, which an assets directory can add to.
+func UseTheme(name string) error {
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+	if _, err := fs.Stat(assets, themePath(name)); err != nil {
+		return fmt.Errorf("assets error: unknown theme: theme=%q; themes=%q", name, themes(assets))
+	}
+	theme = name
+	return nil
+}
+
+// Themes returns the names of the themes that can be picked
+func Themes() []string {
+	assets, _ := Assets()
+	return themes(assets)
+}
+
+func themes(assets fs.FS) []string {
+	names := make([]string, 0)
+	files, _ := fs.Glob(assets, "static/themes/*.css")
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(path.Base(file), ".css"))
+	}
+	return names
+}
+
+// Theme returns the name of the picked theme
+func Theme() string {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	return theme
+}
+
+func themePath(name string) string {
+	return "static/themes/" + name + ".css"
+}
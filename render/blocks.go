@@ -0,0 +1,55 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// elements that never have a closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Blocks splits an HTML fragment into its top-level nodes. Whitespace
+// between the top-level nodes is dropped.
+func Blocks(fragment string) []string {
+	blocks := make([]string, 0)
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	var current bytes.Buffer
+	depth := 0
+
+	flush := func() {
+		if block := current.String(); strings.TrimSpace(block) != "" {
+			blocks = append(blocks, block)
+		}
+		current.Reset()
+	}
+
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			flush()
+			return blocks
+		}
+		current.Write(tokenizer.Raw())
+
+		switch tt {
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if !voidElements[string(name)] {
+				depth++
+			}
+		case html.EndTagToken:
+			if depth > 0 {
+				depth--
+			}
+		}
+		if depth == 0 {
+			flush()
+		}
+	}
+}
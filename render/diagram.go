@@ -0,0 +1,215 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Mermaid diagrams are drawn by the browser; the languages of DiagramCommands
+// are drawn while rendering
+const Mermaid = "mermaid"
+
+// how long a diagram command gets to draw a diagram
+const diagramTimeout = 10 * time.Second
+
+// how many drawn diagrams are kept so that unchanged ones are not drawn again
+const diagramCacheSize = 256
+
+// DiagramCommands maps diagram languages (ie: dot, plantuml) onto the local
+// commands that draw them. A command reads the source of a diagram on stdin
+// and writes an SVG image to stdout, ie: "dot -Tsvg" or "plantuml -tsvg -pipe".
+type DiagramCommands map[string]string
+
+// svgPolicy sanitizes the images diagram commands draw, since their output
+// reaches the page without going through the markdown sanitizer. Only drawing
+// elements and presentation attributes are kept: scripts, event handlers,
+// foreign content and links other than web links and local references are not.
+var svgPolicy = newSVGPolicy()
+
+// ParseDiagramCommand parses a "language=command" setting. The arguments of
+// the command are separated by spaces and may be quoted.
+func ParseDiagramCommand(setting string) (string, string, error) {
+	eq := strings.IndexByte(setting, '=')
+	if eq <= 0 || len(SplitCommand(setting[eq+1:])) == 0 {
+		return "", "", fmt.Errorf("diagram error: expected language=command: setting=%q", setting)
+	}
+	return strings.ToLower(strings.TrimSpace(setting[:eq])), strings.TrimSpace(setting[eq+1:]), nil
+}
+
+// diagrams draws the diagram fences of rendered HTML. Mermaid fences become
+// placeholders holding their source for the browser to draw, when it can.
+type diagrams struct {
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func (d *diagrams) replace(fragment string, commands DiagramCommands, mermaid bool) string {
+	return replaceCodeBlocks(fragment, func(lang, code string) (string, bool) {
+		lang = strings.ToLower(lang)
+		if lang == Mermaid {
+			return diagramMarkup(lang, "", "", code), mermaid
+		}
+		command, ok := commands[lang]
+		if !ok {
+			return "", false
+		}
+		return d.draw(lang, command, code), true
+	})
+}
+
+// draw runs the command of a diagram, reusing the drawing of an unchanged one
+func (d *diagrams) draw(lang, command, code string) string {
+	key := lang + "\x00" + command + "\x00" + code
+	d.mu.Lock()
+	markup, ok := d.cache[key]
+	d.mu.Unlock()
+	if ok {
+		return markup
+	}
+
+	svg, err := runDiagramCommand(command, code)
+	if err != nil {
+		markup = diagramMarkup(lang, "", err.Error(), code)
+	} else {
+		markup = diagramMarkup(lang, svgPolicy.Sanitize(svg), "", code)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cache == nil || len(d.cache) >= diagramCacheSize {
+		d.cache = make(map[string]string)
+	}
+	d.cache[key] = markup
+	return markup
+}
+
+// runDiagramCommand draws a diagram with a local command
+func runDiagramCommand(command, code string) (string, error) {
+	args := SplitCommand(command)
+	ctx, cancel := context.WithTimeout(context.Background(), diagramTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(code)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("%s: %v", args[0], err)
+	}
+
+	// drop the XML prolog and doctype that come before the image
+	svg := stdout.String()
+	start := strings.Index(svg, "<svg")
+	if start < 0 {
+		return "", fmt.Errorf("%s: the command did not write an SVG image", args[0])
+	}
+	return svg[start:], nil
+}
+
+func newSVGPolicy() *bluemonday.Policy {
+	// references to other elements of the image, ie: url(#arrow)
+	local := regexp.MustCompile(`^#[\w\-.:]*$`)
+	web := regexp.MustCompile(`^(#[\w\-.:]*|https?://[^\s"'<>\\]*)$`)
+	paint := regexp.MustCompile(`^(url\(#[\w\-.:]*\)|[\w\s#%.,()\-]*)$`)
+	// CSS declarations without url(), expression() or anything else that
+	// could load or run something
+	style := regexp.MustCompile(`^([\w\-]+\s*:\s*([\w\s#%.,'"\-]|rgba?\([\d\s.,%]*\))*;?\s*)*$`)
+	text := regexp.MustCompile(`^[^<>]*$`)
+
+	p := bluemonday.NewPolicy()
+	p.AllowNoAttrs().OnElements(
+		"svg", "g", "defs", "symbol", "use", "title", "desc", "switch",
+		"path", "rect", "circle", "ellipse", "line", "polyline", "polygon",
+		"text", "tspan", "textpath", "marker", "clippath", "mask", "pattern",
+		"lineargradient", "radialgradient", "stop", "a",
+	)
+	p.SkipElementsContent("foreignobject")
+	p.AllowAttrs(
+		"id", "class", "x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry",
+		"dx", "dy", "width", "height", "d", "points", "viewbox", "preserveaspectratio",
+		"transform", "opacity", "fill-opacity", "fill-rule", "clip-rule",
+		"stroke-width", "stroke-dasharray", "stroke-dashoffset", "stroke-linecap",
+		"stroke-linejoin", "stroke-miterlimit", "stroke-opacity", "font-size",
+		"font-weight", "font-style", "text-anchor", "dominant-baseline",
+		"alignment-baseline", "baseline-shift", "text-decoration", "textlength",
+		"lengthadjust", "letter-spacing", "word-spacing", "visibility", "display",
+		"offset", "stop-opacity", "markerwidth", "markerheight", "markerunits",
+		"refx", "refy", "orient", "gradientunits", "gradienttransform",
+		"patternunits", "patterntransform", "clippathunits", "maskunits",
+		"xmlns", "xmlns:xlink", "version", "role", "aria-label",
+	).Matching(text).Globally()
+	p.AllowAttrs("fill", "stroke", "stop-color", "color", "font-family").Matching(paint).Globally()
+	p.AllowAttrs("clip-path", "mask", "marker-start", "marker-mid", "marker-end", "filter").Matching(paint).Globally()
+	p.AllowAttrs("style").Matching(style).Globally()
+	p.AllowAttrs("href", "xlink:href").Matching(local).OnElements("use", "textpath")
+	p.AllowAttrs("xlink:href").Matching(web).OnElements("a")
+	p.AllowAttrs("xlink:title", "target").Matching(text).OnElements("a")
+	p.AllowAttrs("href").OnElements("a")
+	p.RequireParseableURLs(true)
+	p.AllowURLSchemes("http", "https")
+	p.AllowRelativeURLs(false)
+	return p
+}
+
+// diagramMarkup returns a drawn diagram, the error that kept it from being
+// drawn along with its source, or the source for the browser to draw
+func diagramMarkup(lang, svg, failure, code string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<div class="diagram diagram-%s">`, html.EscapeString(lang))
+	switch {
+	case svg != "":
+		buf.WriteString(svg)
+	case failure != "":
+		fmt.Fprintf(&buf, `<pre class="diagram-error">%s</pre>`, html.EscapeString(failure))
+		fmt.Fprintf(&buf, `<pre class="diagram-source">%s</pre>`, html.EscapeString(code))
+	default:
+		fmt.Fprintf(&buf, `<pre class="diagram-source">%s</pre>`, html.EscapeString(code))
+	}
+	buf.WriteString("</div>")
+	return buf.String()
+}
+
+// SplitCommand splits a command line into its arguments, keeping quoted
+// arguments together. The commands godown runs are not passed to a shell.
+func SplitCommand(command string) []string {
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg := false
+	quote := rune(0)
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,177 @@
+package render
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeDiagram(t *testing.T) {
+	tests := []struct {
+		name    string
+		svg     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "graphviz output",
+			svg: `<svg width="62pt" height="116pt" viewBox="0.00 0.00 62.00 116.00" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">
+<g id="graph0" class="graph" transform="scale(1 1) rotate(0) translate(4 112)">
+<title>G</title>
+<polygon fill="white" stroke="transparent" points="-4,4 -4,-112 58,-112 58,4 -4,4"/>
+<g id="node1" class="node"><title>a</title>
+<ellipse fill="none" stroke="black" cx="27" cy="-90" rx="27" ry="18"/>
+<text text-anchor="middle" x="27" y="-86.3" font-family="Times,serif" font-size="14.00">a &amp; b</text>
+</g>
+<path fill="none" stroke="#ff0000" stroke-dasharray="5,2" d="M27,-71.7C27,-63.98 27,-54.71 27,-46.11"/>
+</g>
+</svg>`,
+			want: []string{
+				`viewbox="0.00 0.00 62.00 116.00"`,
+				`<g id="graph0" class="graph" transform="scale(1 1) rotate(0) translate(4 112)">`,
+				`<title>G</title>`,
+				`<polygon fill="white" stroke="transparent" points="-4,4 -4,-112 58,-112 58,4 -4,4"/>`,
+				`<ellipse fill="none" stroke="black" cx="27" cy="-90" rx="27" ry="18"/>`,
+				`font-family="Times,serif"`,
+				`>a &amp; b</text>`,
+				`stroke="#ff0000" stroke-dasharray="5,2"`,
+				`</svg>`,
+			},
+		},
+		{
+			name:    "script",
+			svg:     `<svg><script>alert(1)</script><script xlink:href="http://x/a.js"></script><rect width="1"/></svg>`,
+			want:    []string{`<rect width="1"/>`},
+			notWant: []string{"script", "alert"},
+		},
+		{
+			name:    "event handlers",
+			svg:     `<svg onload="alert(1)"><rect width="1" onclick="alert(2)" onmouseover="alert(3)"/></svg>`,
+			want:    []string{`<svg>`, `<rect width="1"/>`},
+			notWant: []string{"alert", "onload", "onclick"},
+		},
+		{
+			name: "links",
+			svg: `<svg><a href="javascript:alert(1)"><text>1</text></a>` +
+				`<a xlink:href="javascript:alert(2)"><text>2</text></a>` +
+				`<a xlink:href="JaVaScRiPt:alert(3)"><text>3</text></a>` +
+				`<a href="data:text/html;base64,PHNjcmlwdD4="><text>4</text></a>` +
+				`<a xlink:href="https://example.com/x" target="_blank"><text>5</text></a>` +
+				`<a href="https://example.com/y"><text>6</text></a></svg>`,
+			want: []string{
+				`<a xlink:href="https://example.com/x" target="_blank">`,
+				`<a href="https://example.com/y">`,
+				`<text>1</text>`,
+			},
+			notWant: []string{"javascript", "JaVaScRiPt", "alert", "data:"},
+		},
+		{
+			name:    "references",
+			svg:     `<svg><use href="#shape"/><use xlink:href="http://evil.example/x.svg#a"/><rect fill="url(#grad)" stroke="url(http://evil.example/)"/></svg>`,
+			want:    []string{`<use href="#shape"/>`, `fill="url(#grad)"`},
+			notWant: []string{"evil"},
+		},
+		{
+			name:    "foreign content",
+			svg:     `<svg><foreignObject><iframe src="http://evil.example"></iframe><div onclick="x">html</div></foreignObject><image href="http://evil.example/i.png"/><circle r="1"/></svg>`,
+			want:    []string{`<circle r="1"/>`},
+			notWant: []string{"evil", "iframe", "html", "image", "foreignobject"},
+		},
+		{
+			name:    "styles",
+			svg:     `<svg><style>@import url(http://evil.example/);</style><rect style="fill: rgb(1, 2, 3); stroke:#000;"/><rect style="background:url(http://evil.example/)"/><rect style="x:expression(alert(1))"/></svg>`,
+			want:    []string{`<rect style="fill: rgb(1, 2, 3); stroke:#000;"/>`},
+			notWant: []string{"evil", "expression", "@import"},
+		},
+	}
+	for _, test := range tests {
+		got := svgPolicy.Sanitize(test.svg)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: %q does not contain %q", test.name, got, want)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(strings.ToLower(got), strings.ToLower(notWant)) {
+				t.Errorf("%s: %q contains %q", test.name, got, notWant)
+			}
+		}
+	}
+}
+
+func TestDrawDiagram(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat is needed to stand in for a diagram command")
+	}
+	g := &GFM{Diagrams: DiagramCommands{"svg": "cat"}}
+	result, err := g.Render([]byte("```svg\n<?xml version=\"1.0\"?>\n<svg onload=\"alert(1)\"><script>alert(2)</script><rect width=\"1\"/></svg>\n```\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<div class="diagram diagram-svg"><svg><rect width="1"/></svg>`
+	if !strings.Contains(result.HTML, want) {
+		t.Errorf("%q does not contain %q", result.HTML, want)
+	}
+	if strings.Contains(result.HTML, "alert") || strings.Contains(result.HTML, "<?xml") {
+		t.Errorf("%q was not sanitized", result.HTML)
+	}
+}
+
+func TestMermaidFences(t *testing.T) {
+	source := []byte("```mermaid\ngraph TD; a-->b & c\n```\n")
+	tests := []struct {
+		mermaid bool
+		want    string
+	}{
+		{true, `<div class="diagram diagram-mermaid"><pre class="diagram-source">graph TD; a--&gt;b &amp; c` + "\n</pre></div>\n"},
+		{false, `<div class="highlight highlight-mermaid"><pre><code class="hljs language-mermaid">graph TD; a--&gt;b &amp; c` + "\n</code></pre></div>\n"},
+	}
+	for _, test := range tests {
+		result, err := (&GFM{Mermaid: test.mermaid}).Render(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.HTML != test.want {
+			t.Errorf("mermaid %v:\n got %q\nwant %q", test.mermaid, result.HTML, test.want)
+		}
+	}
+}
+
+func TestParseDiagramCommand(t *testing.T) {
+	tests := []struct {
+		setting, lang, command string
+		wantErr                bool
+	}{
+		{"dot=dot -Tsvg", "dot", "dot -Tsvg", false},
+		{" PlantUML = plantuml -tsvg -pipe ", "plantuml", "plantuml -tsvg -pipe", false},
+		{"dot=", "", "", true},
+		{"=dot", "", "", true},
+		{"dot", "", "", true},
+	}
+	for _, test := range tests {
+		lang, command, err := ParseDiagramCommand(test.setting)
+		if (err != nil) != test.wantErr || lang != test.lang || command != test.command {
+			t.Errorf("%q: got %q, %q, %v", test.setting, lang, command, err)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"dot -Tsvg", []string{"dot", "-Tsvg"}},
+		{"  a \t b  ", []string{"a", "b"}},
+		{`nvim --remote-send ":e +{line} {file}<CR>"`, []string{"nvim", "--remote-send", ":e +{line} {file}<CR>"}},
+		{`a 'b "c"' d`, []string{"a", `b "c"`, "d"}},
+		{`a ""`, []string{"a", ""}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		if got := SplitCommand(test.command); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.command, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FrontMatter splits the YAML (between --- lines) or TOML (between +++ lines)
+// front matter off the top of a markdown file and parses it. Files without
+// front matter are returned unchanged with no metadata. Only the commonly used
+// subset of either format is understood: scalars, lists and nested tables.
+func FrontMatter(data []byte) (map[string]interface{}, []byte, error) {
+	var parse func(string) (map[string]interface{}, error)
+	var fence string
+	switch {
+	case hasFence(data, "---"):
+		parse, fence = parseYAML, "---"
+	case hasFence(data, "+++"):
+		parse, fence = parseTOML, "+++"
+	default:
+		return nil, data, nil
+	}
+
+	// the front matter ends at the next fence line
+	start := bytes.IndexByte(data, '\n') + 1
+	end, next := -1, 0
+	for i := start; i < len(data); {
+		lineEnd := bytes.IndexByte(data[i:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(data) - i
+			next = len(data)
+		} else {
+			next = i + lineEnd + 1
+		}
+		line := strings.TrimRight(string(data[i:i+lineEnd]), " \t\r")
+		if line == fence || (fence == "---" && line == "...") {
+			end = i
+			break
+		}
+		i = next
+	}
+	if end < 0 {
+		return nil, data, nil
+	}
+
+	meta, err := parse(string(data[start:end]))
+	if err != nil {
+		return nil, data, err
+	}
+	// keep the lines of the front matter so that line numbers stay the same
+	body := append(bytes.Repeat([]byte("\n"), bytes.Count(data[:next], []byte("\n"))), data[next:]...)
+	return meta, body, nil
+}
+
+// MetaTable renders metadata as a collapsible table
+func MetaTable(meta map[string]interface{}) string {
+	if len(meta) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<details class="front-matter"><summary>Front matter</summary>`)
+	writeMetaTable(&buf, meta)
+	buf.WriteString("</details>\n")
+	return buf.String()
+}
+
+func writeMetaTable(buf *bytes.Buffer, meta map[string]interface{}) {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.WriteString("<table>")
+	for _, key := range keys {
+		buf.WriteString("<tr><th>")
+		buf.WriteString(html.EscapeString(key))
+		buf.WriteString("</th><td>")
+		switch value := meta[key].(type) {
+		case map[string]interface{}:
+			writeMetaTable(buf, value)
+		default:
+			buf.WriteString(html.EscapeString(metaString(value)))
+		}
+		buf.WriteString("</td></tr>")
+	}
+	buf.WriteString("</table>")
+}
+
+// metaString formats a metadata value for display
+func metaString(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = metaString(item)
+		}
+		return strings.Join(items, ", ")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// MetaTitle returns the title field of the metadata, if there is one
+func MetaTitle(meta map[string]interface{}) string {
+	return MetaString(meta, "title")
+}
+
+// MetaString returns a metadata field formatted as text
+func MetaString(meta map[string]interface{}, key string) string {
+	return strings.TrimSpace(metaString(meta[key]))
+}
+
+// MetaList returns a metadata field as a list of strings. A single value
+// becomes a list of one.
+func MetaList(meta map[string]interface{}, key string) []string {
+	switch value := meta[key].(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			if text := strings.TrimSpace(metaString(item)); text != "" {
+				items = append(items, text)
+			}
+		}
+		return items
+	default:
+		if text := strings.TrimSpace(metaString(value)); text != "" {
+			return []string{text}
+		}
+		return nil
+	}
+}
+
+// hasFence reports whether the first line of data is a front matter fence
+func hasFence(data []byte, fence string) bool {
+	i := bytes.IndexByte(data, '\n')
+	return i >= 0 && strings.TrimRight(string(data[:i]), " \t\r") == fence
+}
+
+// ----------------------------------------------------------------------------
+// YAML -----------------------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+func parseYAML(text string) (map[string]interface{}, error) {
+	lines := make([]yamlLine, 0)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			// blank lines still belong to block scalars
+			lines = append(lines, yamlLine{number: i + 1, indent: -1})
+			continue
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(line) - len(trimmed), text: trimmed})
+	}
+	meta, _, err := parseYAMLMap(lines, 0, 0)
+	return meta, err
+}
+
+// parseYAMLMap parses the keys at an indentation and returns the index of the
+// first line after them
+func parseYAMLMap(lines []yamlLine, i, indent int) (map[string]interface{}, int, error) {
+	meta := make(map[string]interface{})
+	for i < len(lines) {
+		line := lines[i]
+		if line.indent < 0 {
+			i++
+			continue
+		}
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, i, fmt.Errorf("front matter error: unexpected indentation: line=%d", line.number)
+		}
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, i, fmt.Errorf("front matter error: expected a key: line=%d", line.number)
+		}
+		i++
+
+		switch {
+		case value == "|" || value == ">" || value == "|-" || value == ">-":
+			var text string
+			text, i = yamlBlockScalar(lines, i, indent, value)
+			meta[key] = text
+		case value != "":
+			meta[key] = yamlScalar(value)
+		default:
+			// the value is the indented block that follows, if any
+			next := nextYAMLLine(lines, i)
+			switch {
+			case next < 0 || lines[next].indent < indent:
+				meta[key] = nil
+			case strings.HasPrefix(lines[next].text, "- ") || lines[next].text == "-":
+				var list []interface{}
+				var err error
+				list, i, err = parseYAMLList(lines, next, lines[next].indent)
+				if err != nil {
+					return nil, i, err
+				}
+				meta[key] = list
+			case lines[next].indent > indent:
+				var nested map[string]interface{}
+				var err error
+				nested, i, err = parseYAMLMap(lines, next, lines[next].indent)
+				if err != nil {
+					return nil, i, err
+				}
+				meta[key] = nested
+			default:
+				meta[key] = nil
+			}
+		}
+	}
+	return meta, i, nil
+}
+
+func parseYAMLList(lines []yamlLine, i, indent int) ([]interface{}, int, error) {
+	list := make([]interface{}, 0)
+	for i < len(lines) {
+		line := lines[i]
+		if line.indent < 0 {
+			i++
+			continue
+		}
+		if line.indent != indent || !(strings.HasPrefix(line.text, "- ") || line.text == "-") {
+			break
+		}
+		item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		i++
+		if _, _, ok := splitYAMLKey(item); ok {
+			// a list of maps: the first key shares the line with the dash
+			itemLines := append([]yamlLine{{number: line.number, indent: indent + 2, text: item}}, lines[i:]...)
+			nested, n, err := parseYAMLMap(itemLines, 0, indent+2)
+			if err != nil {
+				return nil, i, err
+			}
+			list = append(list, nested)
+			i += n - 1
+			continue
+		}
+		list = append(list, yamlScalar(item))
+	}
+	return list, i, nil
+}
+
+// yamlBlockScalar joins the lines of a literal (|) or folded (>) block
+func yamlBlockScalar(lines []yamlLine, i, indent int, style string) (string, int) {
+	parts := make([]string, 0)
+	blockIndent := -1
+	for i < len(lines) {
+		line := lines[i]
+		if line.indent >= 0 && line.indent <= indent {
+			break
+		}
+		if line.indent < 0 {
+			parts = append(parts, "")
+			i++
+			continue
+		}
+		if blockIndent < 0 {
+			blockIndent = line.indent
+		}
+		parts = append(parts, strings.Repeat(" ", line.indent-blockIndent)+line.text)
+		i++
+	}
+	for len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+
+	sep := "\n"
+	if strings.HasPrefix(style, ">") {
+		sep = " "
+	}
+	text := strings.Join(parts, sep)
+	if !strings.HasSuffix(style, "-") && text != "" {
+		text += "\n"
+	}
+	return text, i
+}
+
+func nextYAMLLine(lines []yamlLine, i int) int {
+	for ; i < len(lines); i++ {
+		if lines[i].indent >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitYAMLKey splits a "key: value" line
+func splitYAMLKey(text string) (string, string, bool) {
+	if text == "" || text[0] == '-' || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		n := quotedLength(text)
+		if n < len(text) && text[n] == ':' && (n+1 == len(text) || text[n+1] == ' ') {
+			key, _ := yamlScalar(text[:n]).(string)
+			return key, stripYAMLComment(strings.TrimSpace(text[n+1:])), true
+		}
+		return "", "", false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key := strings.TrimSpace(text[:i])
+			return key, stripYAMLComment(strings.TrimSpace(text[i+1:])), key != ""
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment removes a trailing comment from an unquoted value
+func stripYAMLComment(value string) string {
+	if value == "" || value[0] == '"' || value[0] == '\'' {
+		return value
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		return strings.TrimSpace(value[:i])
+	}
+	if value[0] == '#' {
+		return ""
+	}
+	return value
+}
+
+// yamlScalar converts a flow value: a quoted or plain string, a number, a
+// boolean, null or a [flow, list]
+func yamlScalar(value string) interface{} {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "" || value == "~" || value == "null" || value == "Null" || value == "NULL":
+		return nil
+	case value == "true" || value == "True" || value == "TRUE":
+		return true
+	case value == "false" || value == "False" || value == "FALSE":
+		return false
+	case value[0] == '"':
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+		return strings.Trim(value, `"`)
+	case value[0] == '\'':
+		return strings.Replace(strings.TrimSuffix(value[1:], "'"), "''", "'", -1)
+	case value[0] == '[' && value[len(value)-1] == ']':
+		list := make([]interface{}, 0)
+		for _, item := range splitFlow(value[1 : len(value)-1]) {
+			list = append(list, yamlScalar(item))
+		}
+		return list
+	}
+	return number(value)
+}
+
+// ----------------------------------------------------------------------------
+// TOML -----------------------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+func parseTOML(text string) (map[string]interface{}, error) {
+	meta := make(map[string]interface{})
+	table := meta
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		// [table] or [nested.table]
+		if line[0] == '[' {
+			name := strings.Trim(line, "[] ")
+			table = meta
+			for _, part := range strings.Split(name, ".") {
+				part = strings.Trim(strings.TrimSpace(part), `"'`)
+				next, ok := table[part].(map[string]interface{})
+				if !ok {
+					next = make(map[string]interface{})
+					table[part] = next
+				}
+				table = next
+			}
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("front matter error: expected a key: line=%d", number)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:eq]), `"'`)
+		value := strings.TrimSpace(line[eq+1:])
+
+		// arrays may span lines until their brackets are closed
+		for strings.HasPrefix(value, "[") && flowDepth(value) > 0 {
+			i++
+			if i == len(lines) {
+				return nil, fmt.Errorf("front matter error: unterminated array: line=%d", number)
+			}
+			value += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+		table[key] = tomlValue(strings.TrimSpace(value))
+	}
+	return meta, nil
+}
+
+func tomlValue(value string) interface{} {
+	switch {
+	case value == "true":
+		return true
+	case value == "false":
+		return false
+	case strings.HasPrefix(value, `"`):
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+		return strings.Trim(value, `"`)
+	case strings.HasPrefix(value, "'"):
+		return strings.Trim(value, "'")
+	case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+		list := make([]interface{}, 0)
+		for _, item := range splitFlow(value[1 : len(value)-1]) {
+			list = append(list, tomlValue(item))
+		}
+		return list
+	}
+	return number(strings.Replace(value, "_", "", -1))
+}
+
+// stripTOMLComment removes a # comment that is not inside of a string
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// ----------------------------------------------------------------------------
+// HELPERS --------------------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+// number converts a value to an int or a float if it is one
+func number(value string) interface{} {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	return value
+}
+
+// splitFlow splits the items of a flow list on the commas outside of quotes
+// and brackets
+func splitFlow(text string) []string {
+	items := make([]string, 0)
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// flowDepth returns how many of the brackets opened in text, outside of
+// quotes, are left unclosed
+func flowDepth(text string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+// quotedLength returns the length of the quoted string text starts with
+func quotedLength(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && quote == '"':
+			i++
+		case text[i] == quote:
+			return i + 1
+		}
+	}
+	return len(text)
+}
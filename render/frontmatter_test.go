@@ -0,0 +1,260 @@
+package render
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type meta = map[string]interface{}
+
+type list = []interface{}
+
+func TestFrontMatter(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		meta    meta
+		body    string
+		wantErr bool
+	}{
+		{
+			name: "no front matter",
+			data: "# Title\n\ntext\n",
+			body: "# Title\n\ntext\n",
+		},
+		{
+			name: "yaml",
+			data: "---\ntitle: Hello\n---\n# Body\n",
+			meta: meta{"title": "Hello"},
+			body: "\n\n\n# Body\n",
+		},
+		{
+			name: "yaml ended by dots",
+			data: "---\ntitle: Hello\n...\nbody\n",
+			meta: meta{"title": "Hello"},
+			body: "\n\n\nbody\n",
+		},
+		{
+			name: "fences with trailing spaces and crlf",
+			data: "--- \r\ntitle: Hello\r\n---\r\nbody\r\n",
+			meta: meta{"title": "Hello"},
+			body: "\n\n\nbody\r\n",
+		},
+		{
+			name: "empty yaml",
+			data: "---\n---\nbody",
+			meta: meta{},
+			body: "\n\nbody",
+		},
+		{
+			name: "toml",
+			data: "+++\ntitle = \"Hello\"\n+++\nbody\n",
+			meta: meta{"title": "Hello"},
+			body: "\n\n\nbody\n",
+		},
+		{
+			name: "horizontal rule without a closing fence",
+			data: "---\n# Title\n\ntext\n",
+			body: "---\n# Title\n\ntext\n",
+		},
+		{
+			name:    "horizontal rules around prose",
+			data:    "---\nSome prose, not metadata.\n\n---\nmore\n",
+			body:    "---\nSome prose, not metadata.\n\n---\nmore\n",
+			wantErr: true,
+		},
+		{
+			name: "rule after the first line",
+			data: "\n---\ntitle: x\n---\n",
+			body: "\n---\ntitle: x\n---\n",
+		},
+		{
+			name: "fence without a newline",
+			data: "---",
+			body: "---",
+		},
+	}
+	for _, test := range tests {
+		m, body, err := FrontMatter([]byte(test.data))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+		if !reflect.DeepEqual(m, test.meta) {
+			t.Errorf("%s: got meta %#v, want %#v", test.name, m, test.meta)
+		}
+		if string(body) != test.body {
+			t.Errorf("%s: got body %q, want %q", test.name, body, test.body)
+		}
+		if strings.Count(string(body), "\n") != strings.Count(test.data, "\n") {
+			t.Errorf("%s: body has a different number of lines", test.name)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    meta
+		wantErr bool
+	}{
+		{
+			name: "scalars",
+			text: "s: text\ni: 42\nf: 1.5\nt: true\nf2: False\nn: null\nt2: ~\ne:\n",
+			want: meta{"s": "text", "i": int64(42), "f": 1.5, "t": true, "f2": false, "n": nil, "t2": nil, "e": nil},
+		},
+		{
+			name: "quoting",
+			text: "a: \"x: y # z\"\nb: 'it''s'\nc: \"tab\\there\"\n\"quoted key\": 1\nd: '42'\ne: plain # comment\nf: a#b",
+			want: meta{"a": "x: y # z", "b": "it's", "c": "tab\there", "quoted key": int64(1), "d": "42", "e": "plain", "f": "a#b"},
+		},
+		{
+			name: "colons in values",
+			text: "url: http://example.com:8080/x\ntime: 12:30",
+			want: meta{"url": "http://example.com:8080/x", "time": "12:30"},
+		},
+		{
+			name: "lists",
+			text: "flow: [a, \"b, c\", 3]\nblock:\n  - one\n  - 'two'\n\n  - 3\nflat:\n- x\n- y\nempty: []",
+			want: meta{
+				"flow":  list{"a", "b, c", int64(3)},
+				"block": list{"one", "two", int64(3)},
+				"flat":  list{"x", "y"},
+				"empty": list{},
+			},
+		},
+		{
+			name: "nested maps",
+			text: "author:\n  name: Ada\n  links:\n    home: x.org\n  tags: [a]\ntitle: T",
+			want: meta{
+				"author": meta{"name": "Ada", "links": meta{"home": "x.org"}, "tags": list{"a"}},
+				"title":  "T",
+			},
+		},
+		{
+			name: "list of maps",
+			text: "people:\n  - name: a\n    age: 1\n  - name: b\nnext: 2",
+			want: meta{
+				"people": list{meta{"name": "a", "age": int64(1)}, meta{"name": "b"}},
+				"next":   int64(2),
+			},
+		},
+		{
+			name: "block scalars",
+			text: "literal: |\n  one\n    two\n\n  three\nfolded: >-\n  a\n  b\nafter: x",
+			want: meta{"literal": "one\n  two\n\nthree\n", "folded": "a b", "after": "x"},
+		},
+		{
+			name: "comments and blank lines",
+			text: "# leading\n\na: 1\n  # indented comment\nb: 2\n",
+			want: meta{"a": int64(1), "b": int64(2)},
+		},
+		{
+			name:    "prose",
+			text:    "Some prose without a key",
+			wantErr: true,
+		},
+		{
+			name:    "unexpected indentation",
+			text:    "a: 1\n  b: 2",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got, err := parseYAML(test.text)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\n got %#v\nwant %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    meta
+		wantErr bool
+	}{
+		{
+			name: "scalars",
+			text: "s = \"text\"\nl = 'C:\\path'\ni = 1_000\nf = 2.5\nb = true\nc = false",
+			want: meta{"s": "text", "l": "C:\\path", "i": int64(1000), "f": 2.5, "b": true, "c": false},
+		},
+		{
+			name: "comments",
+			text: "# leading\na = \"x # not a comment\" # comment\nb = 1 # comment",
+			want: meta{"a": "x # not a comment", "b": int64(1)},
+		},
+		{
+			name: "arrays",
+			text: "tags = [\"a\", \"b, c\"]\nnested = [[1, 2], [3]]\nempty = []",
+			want: meta{
+				"tags":   list{"a", "b, c"},
+				"nested": list{list{int64(1), int64(2)}, list{int64(3)}},
+				"empty":  list{},
+			},
+		},
+		{
+			name: "multi-line arrays",
+			text: "tags = [\n  \"a\",\n  \"b\",\n]\nnext = 1\nodd = [ \"]\", # comment ]\n  \"[\" ]",
+			want: meta{"tags": list{"a", "b"}, "next": int64(1), "odd": list{"]", "["}},
+		},
+		{
+			name: "tables",
+			text: "title = \"T\"\n[author]\nname = \"Ada\"\n[author.links]\nhome = \"x.org\"\n[\"quoted\"]\nk = 1",
+			want: meta{
+				"title":  "T",
+				"author": meta{"name": "Ada", "links": meta{"home": "x.org"}},
+				"quoted": meta{"k": int64(1)},
+			},
+		},
+		{
+			name: "quoted keys",
+			text: "\"a b\" = 1\n'c' = 2",
+			want: meta{"a b": int64(1), "c": int64(2)},
+		},
+		{
+			name:    "unterminated array",
+			text:    "tags = [\n  \"a\",",
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			text:    "just text",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got, err := parseTOML(test.text)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\n got %#v\nwant %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMetaList(t *testing.T) {
+	m := meta{"list": list{"a", " ", int64(2)}, "one": "x", "blank": " "}
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"list", []string{"a", "2"}},
+		{"one", []string{"x"}},
+		{"blank", nil},
+		{"missing", nil},
+	}
+	for _, test := range tests {
+		if got := MetaList(m, test.key); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package render
+
+import (
+	"log"
+
+	md "github.com/shurcooL/github_flavored_markdown"
+)
+
+// GFM renders GitHub Flavored Markdown
+type GFM struct {
+	// MetaTable shows the front matter of a file in a table above its content
+	MetaTable bool
+
+	// Diagrams are the local commands that draw diagram fences other than
+	// mermaid, which the browser draws
+	Diagrams DiagramCommands
+
+	// Mermaid leaves mermaid fences for the browser to draw; without it, as
+	// when the mermaid script is not bundled, they stay plain code blocks
+	Mermaid bool
+
+	diagrams diagrams
+}
+
+// NewGFM is the constructor for the GitHub Flavored Markdown renderer
+func NewGFM() *GFM {
+	return &GFM{}
+}
+
+// Render converts the markdown data into sanitized HTML with highlighted code
+// and an outline of its headings; a [TOC] line becomes a table of contents.
+// Front matter is left out of the HTML and returned as the result's metadata,
+// and math is kept away from the markdown so that it reaches the page intact.
+// Every top-level block is traced back to the source lines it came from.
+func (g *GFM) Render(data []byte) (*Result, error) {
+	meta, body, err := FrontMatter(data)
+	if err != nil {
+		// show the broken front matter rather than nothing at all
+		log.Printf("render warning: cannot parse front matter: err=%q\n", err)
+	}
+	protected, math := ProtectMath(body)
+	out := []byte(math.Restore(string(md.Markdown(protected))))
+	result := &Result{
+		HTML:  Highlight(g.diagrams.replace(string(out), g.Diagrams, g.Mermaid)),
+		Title: MetaTitle(meta),
+		Meta:  meta,
+	}
+	if result.Title == "" {
+		result.Title = Title(out)
+	}
+	result.Outline = Outline(result.HTML)
+	result.HTML = ExpandTOC(result.HTML, result.Outline)
+	if g.MetaTable {
+		result.HTML = MetaTable(meta) + result.HTML
+	}
+	result.Lines = SourceLines(result.HTML, body)
+	return result, nil
+}
@@ -0,0 +1,262 @@
+package render
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/shurcooL/highlight_diff"
+	"github.com/shurcooL/highlight_go"
+	"github.com/sourcegraph/syntaxhighlight"
+	xhtml "golang.org/x/net/html"
+)
+
+// hljsConfig gives the tokens of the vendored highlighters the classes
+// highlight.js uses, so that its themes can style the code
+var hljsConfig = syntaxhighlight.HTMLConfig{
+	String:        "hljs-string",
+	Keyword:       "hljs-keyword",
+	Comment:       "hljs-comment",
+	Type:          "hljs-type",
+	Literal:       "hljs-literal",
+	Tag:           "hljs-tag",
+	HTMLTag:       "hljs-name",
+	HTMLAttrName:  "hljs-attr",
+	HTMLAttrValue: "hljs-string",
+	Decimal:       "hljs-number",
+}
+
+// diffConfig gives the kinds of lines highlight_diff tells apart the classes
+// highlight.js uses: unchanged, added, deleted, hunk and file header lines
+var diffConfig = highlight_diff.HTMLConfig{"", "hljs-addition", "hljs-deletion", "hljs-meta", "hljs-meta"}
+
+// the kinds of diff lines, which index diffConfig
+const (
+	diffAdded   syntaxhighlight.Kind = 1
+	diffDeleted syntaxhighlight.Kind = 2
+	diffHeader  syntaxhighlight.Kind = 4
+)
+
+// languages whose blocks are only escaped since their text is not code
+var plainLanguages = map[string]struct{}{
+	"text": {}, "txt": {}, "plain": {}, "plaintext": {}, "nohighlight": {},
+	"markdown": {}, "md": {}, "csv": {}, "log": {}, Mermaid: {},
+}
+
+// languages whose comments start with a # rather than the // and /* */ of
+// the generic scanner. Only their comments are told apart from what the
+// generic scanner finds in the rest of the code: their keywords are not.
+var hashCommentLanguages = map[string]struct{}{
+	"sh": {}, "bash": {}, "shell": {}, "zsh": {}, "fish": {}, "console": {},
+	"python": {}, "py": {}, "ruby": {}, "rb": {}, "perl": {}, "pl": {}, "r": {},
+	"yaml": {}, "yml": {}, "toml": {}, "ini": {}, "conf": {},
+	"make": {}, "makefile": {}, "dockerfile": {}, "cmake": {},
+	"elixir": {}, "ex": {}, "nim": {}, "powershell": {}, "ps1": {},
+}
+
+// Highlight highlights the code of every fenced code block with a language in
+// an HTML fragment. Any markup already inside the block is replaced, so
+// fragments that were partially highlighted by a markdown library end up
+// highlighted the same way as every other block.
+func Highlight(fragment string) string {
+	return replaceCodeBlocks(fragment, func(lang, code string) (string, bool) {
+		class := html.EscapeString(lang)
+		return `<div class="highlight highlight-` + class + `"><pre><code class="hljs language-` + class + `">` +
+			HighlightCode(code, lang) + "</code></pre></div>", true
+	})
+}
+
+// replaceCodeBlocks calls replace with the language and text of every fenced
+// code block with a language in an HTML fragment. The blocks are expected in
+// the form GitHub renders them:
+//
+//	<div class="highlight highlight-LANG"><pre>CODE</pre></div>
+//
+// replace returns the HTML that takes the place of the whole block, or false
+// to leave the block as it is.
+func replaceCodeBlocks(fragment string, replace func(lang, code string) (string, bool)) string {
+	var out bytes.Buffer
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(fragment))
+	var div []byte
+	lang := ""
+	for {
+		tt := tokenizer.Next()
+		if tt == xhtml.ErrorToken {
+			out.Write(div)
+			return out.String()
+		}
+		raw := tokenizer.Raw()
+		name, hasAttr := tokenizer.TagName()
+		switch {
+		case tt == xhtml.StartTagToken && string(name) == "div" && hasAttr:
+			out.Write(div)
+			div = append(div[:0], raw...)
+			lang = ""
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = tokenizer.TagAttr()
+				if string(key) == "class" {
+					lang = codeLanguage(string(val))
+				}
+			}
+			if lang == "" {
+				out.Write(div)
+				div = div[:0]
+			}
+		case tt == xhtml.StartTagToken && string(name) == "pre" && lang != "":
+			pre := string(raw)
+			code := preText(tokenizer)
+			replacement, ok := replace(lang, code)
+			if !ok {
+				replacement = string(div) + pre + html.EscapeString(code) + "</pre></div>"
+			}
+			div = div[:0]
+			lang = ""
+
+			// the replacement includes the end of the block
+			next := tokenizer.Next()
+			if next == xhtml.ErrorToken {
+				out.WriteString(replacement)
+				return out.String()
+			}
+			raw := tokenizer.Raw()
+			if name, _ := tokenizer.TagName(); next == xhtml.EndTagToken && string(name) == "div" {
+				out.WriteString(replacement)
+				continue
+			}
+			out.WriteString(strings.TrimSuffix(replacement, "</div>"))
+			out.Write(raw)
+		default:
+			out.Write(div)
+			div = div[:0]
+			lang = ""
+			out.Write(raw)
+		}
+	}
+}
+
+// HighlightCode returns the code as HTML with its tokens wrapped in classed
+// spans. Only Go is tokenized by its own scanner, go/scanner. Diffs are
+// highlighted by line with highlight_diff. Every other language goes through
+// the language-independent scanner of syntaxhighlight, which knows the strings,
+// numbers and comments of C-like languages and a fixed set of their keywords;
+// languages with # comments only get those comments told apart on top of it.
+// Text that is not code is only escaped.
+func HighlightCode(code, lang string) string {
+	lang = strings.ToLower(lang)
+	if _, ok := plainLanguages[lang]; ok {
+		return html.EscapeString(code)
+	}
+
+	var out bytes.Buffer
+	printer := syntaxhighlight.HTMLPrinter(hljsConfig)
+	var err error
+	switch lang {
+	case "go", "golang":
+		err = highlight_go.Print([]byte(code), &out, printer)
+	case "diff", "patch":
+		err = highlightDiff(&out, code)
+	default:
+		if _, ok := hashCommentLanguages[lang]; ok {
+			err = highlightHashComments(&out, code, printer)
+		} else {
+			err = syntaxhighlight.Print(syntaxhighlight.NewScanner([]byte(code)), &out, printer)
+		}
+	}
+	if err != nil {
+		return html.EscapeString(code)
+	}
+	return out.String()
+}
+
+// codeLanguage returns the language of a "highlight highlight-LANG" class list
+func codeLanguage(class string) string {
+	fields := strings.Fields(class)
+	for _, field := range fields {
+		if strings.HasPrefix(field, "highlight-") {
+			return strings.TrimPrefix(field, "highlight-")
+		}
+	}
+	return ""
+}
+
+// preText consumes the tokens up to the end of a pre element and returns the
+// text inside of it
+func preText(tokenizer *xhtml.Tokenizer) string {
+	var text bytes.Buffer
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return text.String()
+		case xhtml.TextToken:
+			text.Write(tokenizer.Text())
+		case xhtml.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "pre" {
+				return text.String()
+			}
+		}
+	}
+}
+
+// highlightDiff highlights the lines of a patch with highlight_diff, which
+// leaves added and deleted lines to be told apart by their first character
+func highlightDiff(out *bytes.Buffer, code string) error {
+	// the scanner drops a last line that does not end in a newline
+	src := code
+	if !strings.HasSuffix(src, "\n") {
+		src += "\n"
+	}
+	printer := highlight_diff.HTMLPrinter(diffConfig)
+	scanner := highlight_diff.NewScanner([]byte(src))
+	read := 0
+	for scanner.Scan() {
+		line, kind := scanner.Token()
+		read += len(line)
+		if read > len(code) {
+			line = line[:len(line)-1]
+		}
+		switch {
+		case bytes.HasPrefix(line, []byte("+++ ")) || bytes.HasPrefix(line, []byte("--- ")):
+			kind = diffHeader
+		case kind == 0 && len(line) > 0 && line[0] == '+':
+			kind = diffAdded
+		case kind == 0 && len(line) > 0 && line[0] == '-':
+			kind = diffDeleted
+		}
+		if err := printer.Print(out, kind, string(line)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// highlightHashComments highlights the lines that are # comments as comments
+// and the code between them with the generic scanner
+func highlightHashComments(out *bytes.Buffer, code string, printer syntaxhighlight.Printer) error {
+	var chunk strings.Builder
+	flush := func() error {
+		if chunk.Len() == 0 {
+			return nil
+		}
+		err := syntaxhighlight.Print(syntaxhighlight.NewScanner([]byte(chunk.String())), out, printer)
+		chunk.Reset()
+		return err
+	}
+	for _, line := range strings.SplitAfter(code, "\n") {
+		text := strings.TrimRight(line, "\n")
+		indent := len(text) - len(strings.TrimLeft(text, " \t"))
+		if !strings.HasPrefix(text[indent:], "#") {
+			chunk.WriteString(line)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		out.WriteString(text[:indent])
+		if err := printer.Print(out, syntaxhighlight.Comment, text[indent:]); err != nil {
+			return err
+		}
+		out.WriteString(line[len(text):])
+	}
+	return flush()
+}
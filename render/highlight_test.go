@@ -0,0 +1,185 @@
+package render
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+// text returns the text of highlighted HTML
+func text(highlighted string) string {
+	return html.UnescapeString(tags.ReplaceAllString(highlighted, ""))
+}
+
+func TestHighlightCode(t *testing.T) {
+	tests := []struct {
+		lang, code string
+		want       []string
+		notWant    []string
+	}{
+		{
+			lang: "go",
+			code: "func main() {\n\t// hi\n\ts := \"x\" + `y`\n\treturn nil, 42\n}\n",
+			want: []string{
+				`<span class="hljs-keyword">func</span>`,
+				`<span class="hljs-comment">// hi</span>`,
+				`<span class="hljs-string">&#34;x&#34;</span>`,
+				`<span class="hljs-string">` + "`y`" + `</span>`,
+				`<span class="hljs-literal">nil</span>`,
+				`<span class="hljs-number">42</span>`,
+			},
+		},
+		{
+			lang: "Golang",
+			code: "package main",
+			want: []string{`<span class="hljs-keyword">package</span>`},
+		},
+		{
+			lang: "javascript",
+			code: "const x = 'a'; /* note */ return new Date(1.5);",
+			want: []string{
+				`<span class="hljs-keyword">const</span>`,
+				`<span class="hljs-string">&#39;a&#39;</span>`,
+				`<span class="hljs-comment">/* note */</span>`,
+				`<span class="hljs-keyword">return</span>`,
+				`<span class="hljs-type">Date</span>`,
+				`<span class="hljs-number">1.5</span>`,
+			},
+		},
+		{
+			lang: "python",
+			code: "# comment with if and 'quotes\n  # indented\nif x:\n    return \"s\"\n",
+			want: []string{
+				`<span class="hljs-comment"># comment with if and &#39;quotes</span>`,
+				`  <span class="hljs-comment"># indented</span>`,
+				`<span class="hljs-keyword">if</span>`,
+				`<span class="hljs-string">&#34;s&#34;</span>`,
+			},
+			notWant: []string{`<span class="hljs-keyword">if</span> and`},
+		},
+		{
+			lang: "bash",
+			code: "#!/bin/sh\necho \"$HOME\" # trailing\n",
+			want: []string{
+				`<span class="hljs-comment">#!/bin/sh</span>`,
+				`<span class="hljs-string">&#34;$HOME&#34;</span>`,
+			},
+		},
+		{
+			lang: "diff",
+			code: "diff --git a/x b/x\n--- a/x\n+++ b/x\n@@ -1 +1 @@\n-old\n+new\n same\n",
+			want: []string{
+				`<span class="hljs-meta input-block">diff --git a/x b/x` + "\n</span>",
+				`<span class="hljs-meta input-block">--- a/x` + "\n</span>",
+				`<span class="hljs-meta input-block">+++ b/x` + "\n</span>",
+				`<span class="hljs-meta input-block">@@ -1 +1 @@` + "\n</span>",
+				`<span class="hljs-deletion input-block">-old` + "\n</span>",
+				`<span class="hljs-addition input-block">+new` + "\n</span>",
+				"</span> same\n",
+			},
+		},
+		{
+			lang: "patch",
+			code: "-a\n\\ No newline at end of file\n+b",
+			want: []string{
+				`<span class="hljs-deletion input-block">-a` + "\n</span>",
+				"\\ No newline at end of file\n",
+				`<span class="hljs-addition input-block">+b</span>`,
+			},
+		},
+		{
+			lang:    "text",
+			code:    "if <b> & 'x'",
+			want:    []string{"if &lt;b&gt; &amp; &#39;x&#39;"},
+			notWant: []string{"<span"},
+		},
+		{
+			lang:    "markdown",
+			code:    "# Title",
+			notWant: []string{"<span"},
+		},
+	}
+	for _, test := range tests {
+		got := HighlightCode(test.code, test.lang)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: %q does not contain %q", test.lang, got, want)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(got, notWant) {
+				t.Errorf("%s: %q contains %q", test.lang, got, notWant)
+			}
+		}
+	}
+}
+
+func TestHighlightCodeKeepsText(t *testing.T) {
+	codes := []string{
+		"",
+		"\n\n",
+		"don't stop",
+		"unterminated \"string\nnext line",
+		"/* open comment",
+		"x := `raw\nstring",
+		"<a href=\"x\">&amp;</a>",
+		"# only a comment",
+		"  # indented\n\tcode # not a comment line\n",
+		"héllo wörld → λ",
+		"no newline at the end",
+		"-\n+\n@@\n",
+	}
+	langs := []string{"go", "js", "c", "python", "sh", "diff", "html", "text", "unknown"}
+	for _, lang := range langs {
+		for _, code := range codes {
+			if got := text(HighlightCode(code, lang)); got != code {
+				t.Errorf("%s: text of %q changed to %q", lang, code, got)
+			}
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name, fragment, want string
+	}{
+		{
+			name:     "fenced block",
+			fragment: `<div class="highlight highlight-go"><pre>x := 1</pre></div>`,
+			want:     `<div class="highlight highlight-go"><pre><code class="hljs language-go">x <span class="hljs-keyword">:=</span> <span class="hljs-number">1</span></code></pre></div>`,
+		},
+		{
+			name:     "markup from a markdown library is replaced",
+			fragment: `<div class="highlight highlight-go"><pre><span class="k">return</span> &#34;s&#34;</pre></div>`,
+			want:     `<div class="highlight highlight-go"><pre><code class="hljs language-go"><span class="hljs-keyword">return</span> <span class="hljs-string">&#34;s&#34;</span></code></pre></div>`,
+		},
+		{
+			name:     "block without a language",
+			fragment: `<pre><code>x := 1</code></pre>`,
+			want:     `<pre><code>x := 1</code></pre>`,
+		},
+		{
+			name:     "other divs",
+			fragment: `<div class="note"><p>return</p></div>`,
+			want:     `<div class="note"><p>return</p></div>`,
+		},
+		{
+			name:     "text around blocks",
+			fragment: "<p>a</p>\n<div class=\"highlight highlight-diff\"><pre>+x\n</pre></div>\n<p>b</p>",
+			want:     "<p>a</p>\n<div class=\"highlight highlight-diff\"><pre><code class=\"hljs language-diff\"><span class=\"hljs-addition input-block\">+x\n</span></code></pre></div>\n<p>b</p>",
+		},
+		{
+			name:     "language is escaped",
+			fragment: `<div class="highlight highlight-a&quot;b"><pre>x</pre></div>`,
+			want:     `<div class="highlight highlight-a&#34;b"><pre><code class="hljs language-a&#34;b">x</code></pre></div>`,
+		},
+	}
+	for _, test := range tests {
+		if got := Highlight(test.fragment); got != test.want {
+			t.Errorf("%s:\n got %q\nwant %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Index returns a markdown page linking to every file, grouped by directory.
+// The files at the top come first, then every directory in order under a
+// heading of its own. The paths are slash separated and relative to the
+// directory being indexed; link returns the url a path is linked to.
+func Index(title string, paths []string, link func(rel string) string) []byte {
+	groups := make(map[string][]string)
+	for _, rel := range paths {
+		dir := path.Dir(rel)
+		groups[dir] = append(groups[dir], rel)
+	}
+	dirs := make([]string, 0, len(groups))
+	for dir := range groups {
+		if dir != "." {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s\n\n", title)
+	if len(paths) == 0 {
+		buf.WriteString("_No markdown files found._\n")
+	}
+	for _, dir := range append([]string{"."}, dirs...) {
+		files := groups[dir]
+		if len(files) == 0 {
+			continue
+		}
+		if dir != "." {
+			fmt.Fprintf(&buf, "\n## %s/\n\n", dir)
+		}
+		sort.Strings(files)
+		for _, rel := range files {
+			fmt.Fprintf(&buf, "- [%s](%s)\n", escapeLinkText(path.Base(rel)), link(rel))
+		}
+	}
+	return buf.Bytes()
+}
+
+// escapes the characters that have a meaning inside of markdown link text
+func escapeLinkText(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `*`, `\*`, `_`, `\_`)
+	return replacer.Replace(text)
+}
@@ -0,0 +1,45 @@
+package render
+
+import (
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	link := func(rel string) string { return "/" + rel }
+	tests := []struct {
+		name  string
+		paths []string
+		want  string
+	}{
+		{
+			name: "no files",
+			want: "# Notes\n\n_No markdown files found._\n",
+		},
+		{
+			name:  "files at the top",
+			paths: []string{"b.md", "a.md"},
+			want:  "# Notes\n\n- [a.md](/a.md)\n- [b.md](/b.md)\n",
+		},
+		{
+			name:  "files at the top that sort after a directory",
+			paths: []string{"z.md", "sub/a.md", "a.md"},
+			want:  "# Notes\n\n- [a.md](/a.md)\n- [z.md](/z.md)\n\n## sub/\n\n- [a.md](/sub/a.md)\n",
+		},
+		{
+			name:  "directories in order",
+			paths: []string{"b/x.md", "a/c/y.md", "a/z.md", "a/b.md"},
+			want: "# Notes\n\n\n## a/\n\n- [b.md](/a/b.md)\n- [z.md](/a/z.md)\n" +
+				"\n## a/c/\n\n- [y.md](/a/c/y.md)\n\n## b/\n\n- [x.md](/b/x.md)\n",
+		},
+		{
+			name:  "link text is escaped",
+			paths: []string{"[draft]_*.md"},
+			want:  "# Notes\n\n- [\\[draft\\]\\_\\*.md](/[draft]_*.md)\n",
+		},
+	}
+	for _, test := range tests {
+		if got := string(Index("Notes", test.paths, link)); got != test.want {
+			t.Errorf("%s:\n got %q\nwant %q", test.name, got, test.want)
+		}
+	}
+}
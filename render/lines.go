@@ -0,0 +1,234 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// LineRange is the span of source lines, counting from 1, that a top-level
+// block of a render came from. Blocks that cannot be traced back to the
+// source have an empty range.
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+var (
+	atxHeading    = regexp.MustCompile(`^(#{1,6})(\s|$)`)
+	hrule         = regexp.MustCompile(`^((\*\s*){3,}|(-\s*){3,}|(_\s*){3,})$`)
+	listItem      = regexp.MustCompile(`^([*+-]|\d+\.)\s`)
+	orderedItem   = regexp.MustCompile(`^\d+\.\s`)
+	referenceDef  = regexp.MustCompile(`^\[[^\]]+\]:\s*\S`)
+	htmlBlock     = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9]*)`)
+	tableDivider  = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	setextHeading = regexp.MustCompile(`^(=+|-+)\s*$`)
+)
+
+// sourceBlock is a top-level block of markdown source and the tag of the
+// element it is expected to render as
+type sourceBlock struct {
+	tag        string
+	text       string
+	start, end int
+}
+
+// how many words of a rendered block are looked for in the source
+const matchWords = 3
+
+// SourceLines returns the source lines of every top-level block of a render,
+// in the order of Blocks(fragment). The blocks are matched up with the blocks
+// of the markdown source by the elements they render as and the first words
+// of their text, so blocks that were added to the render, such as a table of
+// contents, are skipped over.
+func SourceLines(fragment string, source []byte) []LineRange {
+	blocks := Blocks(fragment)
+	sources := sourceBlocks(source)
+	lines := make([]LineRange, len(blocks))
+	next := 0
+	for i, block := range blocks {
+		tag, words := blockSignature(block)
+		for j := next; j < len(sources); j++ {
+			if sources[j].tag == tag && containsWords(sources[j].text, words) {
+				lines[i] = LineRange{Start: sources[j].start, End: sources[j].end}
+				next = j + 1
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// blockSignature returns the name of the first element of a block and the
+// first words of its text
+func blockSignature(block string) (string, []string) {
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(block))
+	tag := ""
+	words := make([]string, 0, matchWords)
+	for len(words) < matchWords {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return tag, words
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if tag == "" {
+				name, _ := tokenizer.TagName()
+				tag = string(name)
+			}
+		case xhtml.TextToken:
+			for _, word := range strings.FieldsFunc(string(tokenizer.Text()), isNotWordRune) {
+				if len(words) < matchWords {
+					words = append(words, word)
+				}
+			}
+		}
+	}
+	return tag, words
+}
+
+func containsWords(text string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNotWordRune(r rune) bool {
+	return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// sourceBlocks splits markdown into its top-level blocks the way blackfriday
+// does, closely enough to tell which lines a block came from
+func sourceBlocks(source []byte) []sourceBlock {
+	lines := strings.Split(strings.Replace(string(source), "\r\n", "\n", -1), "\n")
+	blocks := make([]sourceBlock, 0)
+	add := func(tag string, start, end int) {
+		text := strings.Join(lines[start:end+1], "\n")
+		blocks = append(blocks, sourceBlock{tag: tag, text: text, start: start + 1, end: end + 1})
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		text := strings.TrimLeft(line, " ")
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case isIndentedCode(line):
+			end := i
+			for j := i; j < len(lines) && (isIndentedCode(lines[j]) || strings.TrimSpace(lines[j]) == ""); j++ {
+				if strings.TrimSpace(lines[j]) != "" {
+					end = j
+				}
+			}
+			add("pre", i, end)
+			i = end + 1
+		case strings.HasPrefix(text, "```") || strings.HasPrefix(text, "~~~"):
+			end := closingFence(lines, i)
+			tag := "pre"
+			if isMathFence(text) {
+				tag = "p"
+			} else if strings.TrimSpace(strings.TrimLeft(text, text[:1])) != "" {
+				tag = "div"
+			}
+			add(tag, i, end-1)
+			i = end
+		case atxHeading.MatchString(text):
+			add("h"+string(rune('0'+len(atxHeading.FindStringSubmatch(text)[1]))), i, i)
+			i++
+		case hrule.MatchString(strings.TrimSpace(text)):
+			add("hr", i, i)
+			i++
+		case strings.HasPrefix(text, ">"):
+			end := paragraphEnd(lines, i)
+			add("blockquote", i, end)
+			i = end + 1
+		case listItem.MatchString(text):
+			tag := "ul"
+			if orderedItem.MatchString(text) {
+				tag = "ol"
+			}
+			end := listEnd(lines, i)
+			add(tag, i, end)
+			i = end + 1
+		case referenceDef.MatchString(text):
+			// link definitions are not rendered
+			i++
+		case htmlBlock.MatchString(text):
+			end := paragraphEnd(lines, i)
+			add(strings.ToLower(htmlBlock.FindStringSubmatch(text)[1]), i, end)
+			i = end + 1
+		case strings.Contains(line, "|") && i+1 < len(lines) && strings.Contains(lines[i+1], "-") && tableDivider.MatchString(strings.TrimSpace(lines[i+1])):
+			end := paragraphEnd(lines, i)
+			add("table", i, end)
+			i = end + 1
+		default:
+			end, tag := i, "p"
+			for j := i + 1; j < len(lines); j++ {
+				next := strings.TrimLeft(lines[j], " ")
+				if setextHeading.MatchString(next) {
+					end, tag = j, "h1"
+					if next[0] == '-' {
+						tag = "h2"
+					}
+					break
+				}
+				if strings.TrimSpace(next) == "" || interruptsParagraph(lines[j]) {
+					break
+				}
+				end = j
+			}
+			add(tag, i, end)
+			i = end + 1
+		}
+	}
+	return blocks
+}
+
+// interruptsParagraph reports whether a line starts a new block even without
+// a blank line before it
+func interruptsParagraph(line string) bool {
+	text := strings.TrimLeft(line, " ")
+	return isIndentedCode(line) || atxHeading.MatchString(text) || hrule.MatchString(strings.TrimSpace(text)) ||
+		strings.HasPrefix(text, "```") || strings.HasPrefix(text, "~~~") ||
+		strings.HasPrefix(text, ">") || listItem.MatchString(text)
+}
+
+// paragraphEnd returns the last line before the next blank line
+func paragraphEnd(lines []string, start int) int {
+	end := start
+	for end+1 < len(lines) && strings.TrimSpace(lines[end+1]) != "" {
+		end++
+	}
+	return end
+}
+
+// listEnd returns the last line of a list. After a blank line the list only
+// goes on with another item of the same kind or a line indented like code.
+func listEnd(lines []string, start int) int {
+	end := start
+	blank := false
+	ordered := orderedItem.MatchString(strings.TrimLeft(lines[start], " "))
+	for j := start + 1; j < len(lines); j++ {
+		line := lines[j]
+		text := strings.TrimLeft(line, " ")
+		switch {
+		case strings.TrimSpace(line) == "":
+			blank = true
+			continue
+		case isIndentedCode(line) || (!blank && len(text) < len(line)):
+			// indented lines belong to the items
+		case listItem.MatchString(text) && !hrule.MatchString(strings.TrimSpace(text)) &&
+			!(blank && orderedItem.MatchString(text) != ordered):
+			// so do the next items
+		case blank || atxHeading.MatchString(text) || hrule.MatchString(strings.TrimSpace(text)) ||
+			strings.HasPrefix(text, "```") || strings.HasPrefix(text, "~~~"):
+			return end
+		}
+		end = j
+		blank = false
+	}
+	return end
+}
@@ -0,0 +1,162 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+)
+
+// blockLines is a source block without its text, for comparing
+type blockLines struct {
+	tag        string
+	start, end int
+}
+
+func TestSourceBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []blockLines
+	}{
+		{
+			name:   "paragraphs",
+			source: "one\ntwo\n\n\nthree",
+			want:   []blockLines{{"p", 1, 2}, {"p", 5, 5}},
+		},
+		{
+			name:   "atx headings",
+			source: "# one\n###### six\n####### seven\n#nospace",
+			want:   []blockLines{{"h1", 1, 1}, {"h6", 2, 2}, {"p", 3, 4}},
+		},
+		{
+			name:   "setext headings",
+			source: "Title\n=====\n\nSub\ntitle\n---\n\ntext\n\n---",
+			want:   []blockLines{{"h1", 1, 2}, {"h2", 4, 6}, {"p", 8, 8}, {"hr", 10, 10}},
+		},
+		{
+			name:   "rules",
+			source: "***\n- - -\n___\n\n--",
+			want:   []blockLines{{"hr", 1, 1}, {"hr", 2, 2}, {"hr", 3, 3}, {"p", 5, 5}},
+		},
+		{
+			name:   "fences",
+			source: "```\ncode\n\nmore\n```\n~~~go\nx\n~~~\n```math\nx\n```\n````\n```\n````\n```\nunclosed",
+			want:   []blockLines{{"pre", 1, 5}, {"div", 6, 8}, {"p", 9, 11}, {"pre", 12, 14}, {"pre", 15, 16}},
+		},
+		{
+			name:   "fence interrupts a paragraph",
+			source: "text\n```\ncode\n```",
+			want:   []blockLines{{"p", 1, 1}, {"pre", 2, 4}},
+		},
+		{
+			// unlike CommonMark, blackfriday lets indented code interrupt a
+			// paragraph
+			name:   "indented code",
+			source: "    a\n\n    b\n\ntext\n    code",
+			want:   []blockLines{{"pre", 1, 3}, {"p", 5, 5}, {"pre", 6, 6}},
+		},
+		{
+			name:   "lists",
+			source: "- a\n  more\n- b\n\n      continued\n\n1. one\n2) two\n\ntext",
+			want:   []blockLines{{"ul", 1, 5}, {"ol", 7, 8}, {"p", 10, 10}},
+		},
+		{
+			name:   "kinds of lists",
+			source: "- a\n1. b\n\n* c\n\n2. d\n\n3) e",
+			want:   []blockLines{{"ul", 1, 4}, {"ol", 6, 6}, {"p", 8, 8}},
+		},
+		{
+			// and ends a list at a blank line unless what follows is indented
+			// like code
+			name:   "list ended by a blank line",
+			source: "- a\n\n  after",
+			want:   []blockLines{{"ul", 1, 1}, {"p", 3, 3}},
+		},
+		{
+			name:   "list ended by a heading",
+			source: "* a\n# h",
+			want:   []blockLines{{"ul", 1, 1}, {"h1", 2, 2}},
+		},
+		{
+			name:   "quotes, html and tables",
+			source: "> a\nb\n\n<div>\nx\n</div>\n\n| a | b |\n|:--|--:|\n| 1 | 2 |",
+			want:   []blockLines{{"blockquote", 1, 2}, {"div", 4, 6}, {"table", 8, 10}},
+		},
+		{
+			name:   "reference definitions",
+			source: "[a]: http://x\n\ntext",
+			want:   []blockLines{{"p", 3, 3}},
+		},
+		{
+			name:   "crlf",
+			source: "# a\r\n\r\ntext\r\n",
+			want:   []blockLines{{"h1", 1, 1}, {"p", 3, 3}},
+		},
+	}
+	for _, test := range tests {
+		got := make([]blockLines, 0)
+		for _, block := range sourceBlocks([]byte(test.source)) {
+			got = append(got, blockLines{block.tag, block.start, block.end})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRenderLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []LineRange
+	}{
+		{
+			name:   "blocks",
+			source: "# Title\n\ntext\nmore\n\n- a\n- b\n",
+			want:   []LineRange{{1, 1}, {3, 4}, {6, 7}},
+		},
+		{
+			name:   "front matter keeps its lines",
+			source: "---\ntitle: T\ntags: [a]\n---\n# Heading\n\ntext\n",
+			want:   []LineRange{{5, 5}, {7, 7}},
+		},
+		{
+			name:   "toml front matter",
+			source: "+++\ntitle = \"T\"\n+++\ntext\n",
+			want:   []LineRange{{4, 4}},
+		},
+		{
+			name:   "table of contents has no source",
+			source: "[TOC]\n\n# A\n\nSetext\n---\n",
+			want:   []LineRange{{}, {3, 3}, {5, 6}},
+		},
+		{
+			name:   "fences and math",
+			source: "```go\nx := 1\n```\n\n$$\nx\n$$\n\n```math\ny\n```\n\ntext $z$\n",
+			want:   []LineRange{{1, 3}, {5, 7}, {9, 11}, {13, 13}},
+		},
+		{
+			name:   "paragraphs that blackfriday ends early",
+			source: "- a\n\n  b\n\ntext\n    code\n",
+			want:   []LineRange{{1, 1}, {3, 3}, {5, 5}, {6, 6}},
+		},
+		{
+			name:   "lists of another kind",
+			source: "- a\n\n1. b\n\n- c\n",
+			want:   []LineRange{{1, 1}, {3, 3}, {5, 5}},
+		},
+		{
+			name:   "rule and quote",
+			source: "a\n\n---\n\n> quoted\n",
+			want:   []LineRange{{1, 1}, {3, 3}, {5, 5}},
+		},
+	}
+	for _, test := range tests {
+		result, err := NewGFM().Render([]byte(test.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result.Lines, test.want) {
+			t.Errorf("%s: got %v, want %v\n%s", test.name, result.Lines, test.want, result.HTML)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package render
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// The classes given to math. Inline math is wrapped in a span; display math is
+// a span too, styled as a block, so that it can sit inside of a paragraph.
+const (
+	classMathInline  = "math math-inline"
+	classMathDisplay = "math math-display"
+)
+
+// mathSpans keeps the math of a document out of the way of the markdown
+// processor. Every formula is swapped for a placeholder that markdown leaves
+// alone, and swapped back once the markdown is rendered.
+type mathSpans struct {
+	prefix string
+	tex    []string
+	markup []string
+}
+
+// ProtectMath replaces the math in markdown data with placeholders. It supports
+// $inline$, $`inline`$, $$display$$ and ```math fenced blocks; math inside of
+// code is left as it is. Restore puts the math back into the rendered HTML.
+func ProtectMath(data []byte) ([]byte, *mathSpans) {
+	m := &mathSpans{prefix: "godownmath"}
+	for bytes.Contains(data, []byte(m.prefix)) {
+		m.prefix += "x"
+	}
+
+	var out bytes.Buffer
+	lines := strings.SplitAfter(string(data), "\n")
+	var text strings.Builder
+	flush := func() {
+		out.WriteString(m.inline(text.String()))
+		text.Reset()
+	}
+
+	blank := true
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		switch {
+		case len(line)-len(trimmed) < 4 && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			flush()
+			end := closingFence(lines, i)
+			block := strings.Join(lines[i:end], "")
+			if isMathFence(trimmed) {
+				tex := strings.Join(lines[i+1:fenceBodyEnd(lines, i, end)], "")
+				out.WriteString(m.add(tex, true))
+				out.WriteString(strings.Repeat("\n", strings.Count(block, "\n")))
+			} else {
+				out.WriteString(block)
+			}
+			i = end - 1
+			blank = false
+		case blank && isIndentedCode(line):
+			// indented code runs until a line that is neither indented nor blank
+			flush()
+			for i < len(lines) && (isIndentedCode(lines[i]) || strings.TrimSpace(lines[i]) == "") {
+				out.WriteString(lines[i])
+				i++
+			}
+			i--
+			blank = strings.TrimSpace(lines[i]) == ""
+		default:
+			text.WriteString(line)
+			blank = strings.TrimSpace(line) == ""
+		}
+	}
+	flush()
+	return out.Bytes(), m
+}
+
+// Restore swaps the placeholders in rendered HTML for the math markup. Inside
+// of tags, such as the anchors of headings, they become the formula's text.
+func (m *mathSpans) Restore(fragment string) string {
+	if len(m.markup) == 0 {
+		return fragment
+	}
+	markup := make([]string, 0, 2*len(m.markup))
+	text := make([]string, 0, 2*len(m.markup))
+	for i := range m.markup {
+		markup = append(markup, m.placeholder(i), m.markup[i])
+		text = append(text, m.placeholder(i), html.EscapeString(m.tex[i]))
+	}
+	inText := strings.NewReplacer(markup...)
+	inTag := strings.NewReplacer(text...)
+
+	var out strings.Builder
+	for fragment != "" {
+		start := strings.IndexByte(fragment, '<')
+		if start < 0 {
+			start = len(fragment)
+		}
+		out.WriteString(inText.Replace(fragment[:start]))
+		fragment = fragment[start:]
+		end := strings.IndexByte(fragment, '>') + 1
+		if end <= 0 {
+			end = len(fragment)
+		}
+		out.WriteString(inTag.Replace(fragment[:end]))
+		fragment = fragment[end:]
+	}
+	return out.String()
+}
+
+// add stores the markup of a formula and returns its placeholder
+func (m *mathSpans) add(tex string, display bool) string {
+	class := classMathInline
+	if display {
+		class = classMathDisplay
+	}
+	tex = strings.TrimSpace(tex)
+	m.tex = append(m.tex, tex)
+	m.markup = append(m.markup, `<span class="`+class+`">`+html.EscapeString(tex)+`</span>`)
+	return m.placeholder(len(m.markup) - 1)
+}
+
+// placeholders end in a letter so that one can never be the start of another
+func (m *mathSpans) placeholder(i int) string {
+	return m.prefix + strconv.Itoa(i) + "z"
+}
+
+// inline replaces the math in text that is outside of code blocks
+func (m *mathSpans) inline(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '$':
+			// markdown has no escape for dollars of its own
+			out.WriteByte('$')
+			i += 2
+		case c == '\\' && i+1 < len(text):
+			out.WriteString(text[i : i+2])
+			i += 2
+		case c == '`':
+			n := codeSpanLength(text[i:])
+			out.WriteString(text[i : i+n])
+			i += n
+		case strings.HasPrefix(text[i:], "$$"):
+			end := strings.Index(text[i+2:], "$$")
+			if end < 0 || end == 0 || hasBlankLine(text[i+2:i+2+end]) {
+				out.WriteString("$$")
+				i += 2
+				continue
+			}
+			tex := text[i+2 : i+2+end]
+			out.WriteString(m.add(tex, true))
+			i += end + 4
+			// keep the line count when the formula ends its line
+			if i == len(text) || text[i] == '\n' {
+				out.WriteString(strings.Repeat("\n", strings.Count(tex, "\n")))
+			}
+		case c == '$' && i+1 < len(text) && text[i+1] == '`':
+			ticks := len(text[i+1:]) - len(strings.TrimLeft(text[i+1:], "`"))
+			n := codeSpanLength(text[i+1:])
+			if n > 2*ticks && i+1+n < len(text) && text[i+1+n] == '$' {
+				out.WriteString(m.add(text[i+1+ticks:i+1+n-ticks], false))
+				i += n + 2
+				continue
+			}
+			out.WriteByte(c)
+			i++
+		case c == '$':
+			n := inlineMathLength(text[i:])
+			if n == 0 {
+				out.WriteByte(c)
+				i++
+				continue
+			}
+			out.WriteString(m.add(text[i+1:i+n-1], false))
+			i += n
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// ----------------------------------------------------------------------------
+// HELPERS --------------------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+// inlineMathLength returns the length of the $formula$ that text starts with.
+// Like GitHub, the formula may not start or end with a space, may not cross a
+// line and may not be followed by a digit, so that prices are left alone. Code
+// spans take precedence, so a dollar inside of one does not end the formula.
+func inlineMathLength(text string) int {
+	if len(text) < 3 || text[1] == ' ' || text[1] == '\t' || text[1] == '\n' || text[1] == '$' {
+		return 0
+	}
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			return 0
+		case '\\':
+			i++
+		case '`':
+			i += codeSpanLength(text[i:]) - 1
+		case '$':
+			if text[i-1] == ' ' || text[i-1] == '\t' {
+				return 0
+			}
+			if i+1 < len(text) && text[i+1] >= '0' && text[i+1] <= '9' {
+				return 0
+			}
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// codeSpanLength returns the length of the code span that text starts with,
+// or of its run of backticks when the span is never closed
+func codeSpanLength(text string) int {
+	ticks := 0
+	for ticks < len(text) && text[ticks] == '`' {
+		ticks++
+	}
+	run := text[:ticks]
+	for i := ticks; i < len(text); {
+		end := strings.Index(text[i:], run)
+		if end < 0 {
+			break
+		}
+		end += i
+		// the closing run must be exactly as long as the opening one
+		after := end + ticks
+		if after < len(text) && text[after] == '`' {
+			for after < len(text) && text[after] == '`' {
+				after++
+			}
+			i = after
+			continue
+		}
+		if hasBlankLine(text[:end]) {
+			break
+		}
+		return after
+	}
+	return ticks
+}
+
+// closingFence returns the index of the line after the fenced block that
+// starts at lines[start]
+func closingFence(lines []string, start int) int {
+	open := strings.TrimLeft(lines[start], " ")
+	marker := open[:1]
+	size := len(open) - len(strings.TrimLeft(open, marker))
+	for i := start + 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, strings.Repeat(marker, size)) && strings.Trim(line, marker) == "" {
+			return i + 1
+		}
+	}
+	return len(lines)
+}
+
+// fenceBodyEnd returns the index of the closing fence of a block, or its end
+// when it was never closed
+func fenceBodyEnd(lines []string, start, end int) int {
+	if end-1 > start {
+		last := strings.TrimSpace(lines[end-1])
+		marker := strings.TrimLeft(lines[start], " ")[:1]
+		if last != "" && strings.Trim(last, marker) == "" {
+			return end - 1
+		}
+	}
+	return end
+}
+
+func isMathFence(line string) bool {
+	info := strings.TrimSpace(strings.TrimLeft(line, line[:1]))
+	return strings.EqualFold(strings.SplitN(info, " ", 2)[0], "math")
+}
+
+func isIndentedCode(line string) bool {
+	return strings.TrimSpace(line) != "" && (strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"))
+}
+
+// hasBlankLine reports whether text has a blank line between two others
+func hasBlankLine(text string) bool {
+	lines := strings.Split(text, "\n")
+	if len(lines) < 3 {
+		return false
+	}
+	for _, line := range lines[1 : len(lines)-1] {
+		if strings.TrimSpace(line) == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+// mathMarkup returns the markup ProtectMath and Restore give a formula
+func mathMarkup(tex string, display bool) string {
+	if display {
+		return `<span class="math math-display">` + tex + `</span>`
+	}
+	return `<span class="math math-inline">` + tex + `</span>`
+}
+
+func TestProtectMath(t *testing.T) {
+	tests := []struct {
+		name, markdown, want string
+	}{
+		{"inline", "a $x^2$ b", "a " + mathMarkup("x^2", false) + " b"},
+		{"at the edges", "$x$", mathMarkup("x", false)},
+		{"two formulas", "$a$ and $b$", mathMarkup("a", false) + " and " + mathMarkup("b", false)},
+		{"prices", "it costs $5 or $10", "it costs $5 or $10"},
+		{"followed by a digit", "$x$2", "$x$2"},
+		{"space after the opening dollar", "$ x$", "$ x$"},
+		{"space before the closing dollar", "$x $", "$x $"},
+		{"across lines", "$x\ny$", "$x\ny$"},
+		{"unclosed", "a $x", "a $x"},
+		{"empty", "$$", "$$"},
+		{"escaped dollar", `\$x$ and \$5`, "$x$ and $5"},
+		{"escaped dollar inside", `$a\$b$`, mathMarkup(`a\$b`, false)},
+		{"other escapes are kept", `\*$x$`, `\*` + mathMarkup("x", false)},
+		{"backtick math", "$`a $ b`$", mathMarkup("a $ b", false)},
+		{"double backtick math", "$``a`b``$", mathMarkup("a`b", false)},
+		{"code span", "`$x$` $y$", "`$x$` " + mathMarkup("y", false)},
+		{"unclosed code span", "`$x$", "`" + mathMarkup("x", false)},
+		{"closing dollar in a code span", "$5 and `$x$`", "$5 and `$x$`"},
+		{"code span across a formula", "$a `b$` c$", mathMarkup("a `b$` c", false)},
+		{"display", "$$x = 1$$", mathMarkup("x = 1", true)},
+		{"display across lines", "$$\nx\n$$\n", mathMarkup("x", true) + "\n\n\n"},
+		{"display with a blank line", "$$\na\n\nb\n$$", "$$\na\n\nb\n$$"},
+		{"display inside a paragraph", "see $$x$$ here", "see " + mathMarkup("x", true) + " here"},
+		{"escaped in display", "$$a < b$$", mathMarkup("a &lt; b", true)},
+		{"math fence", "```math\nx^2\n```\nafter", mathMarkup("x^2", true) + "\n\n\nafter"},
+		{"tilde math fence", "~~~ Math\nx\n~~~", mathMarkup("x", true) + "\n\n"},
+		{"unclosed math fence", "```math\nx\n", mathMarkup("x", true) + "\n\n"},
+		{"code fence", "```go\n$x$\n```\n$y$", "```go\n$x$\n```\n" + mathMarkup("y", false)},
+		{"longer closing fence", "````\n```\n$x$\n````\n$y$", "````\n```\n$x$\n````\n" + mathMarkup("y", false)},
+		{"indented code", "text\n\n    $x$\n$y$", "text\n\n    $x$\n" + mathMarkup("y", false)},
+		{"indented paragraph continuation", "text\n    $x$", "text\n    " + mathMarkup("x", false)},
+	}
+	for _, test := range tests {
+		protected, m := ProtectMath([]byte(test.markdown))
+		if got := m.Restore(string(protected)); got != test.want {
+			t.Errorf("%s:\n got %q\nwant %q", test.name, got, test.want)
+		}
+		if strings.Count(string(protected), "\n") != strings.Count(test.markdown, "\n") {
+			t.Errorf("%s: %q does not keep the lines of %q", test.name, protected, test.markdown)
+		}
+	}
+}
+
+func TestProtectMathPlaceholders(t *testing.T) {
+	// text that looks like a placeholder is never mistaken for one
+	protected, m := ProtectMath([]byte("godownmath0z $x$"))
+	if !strings.HasPrefix(string(protected), "godownmath0z ") {
+		t.Fatalf("text was changed: %q", protected)
+	}
+	if got, want := m.Restore(string(protected)), "godownmath0z "+mathMarkup("x", false); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// many formulas, so that placeholder 1 is a prefix of placeholder 10
+	markdown := strings.Repeat("$a$ ", 11) + "$b$"
+	protected, m = ProtectMath([]byte(markdown))
+	want := strings.Repeat(mathMarkup("a", false)+" ", 11) + mathMarkup("b", false)
+	if got := m.Restore(string(protected)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRestoreMathInTags(t *testing.T) {
+	protected, m := ProtectMath([]byte("# Sum $a<b$"))
+	heading := strings.TrimPrefix(string(protected), "# ")
+	fragment := `<h1><a name="` + heading + `"></a>` + heading + `</h1>`
+	want := `<h1><a name="Sum a&lt;b"></a>Sum ` + mathMarkup("a&lt;b", false) + `</h1>`
+	if got := m.Restore(fragment); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderMath(t *testing.T) {
+	result, err := NewGFM().Render([]byte("Euler: $e^{i\\pi} + 1 = 0$, price $5 and `$code$`.\n\n$$\n\\int_0^1 x_i * y_i\n$$\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		mathMarkup(`e^{i\pi} + 1 = 0`, false),
+		"price $5 and",
+		"<code>$code$</code>",
+		mathMarkup(`\int_0^1 x_i * y_i`, true),
+	} {
+		if !strings.Contains(result.HTML, want) {
+			t.Errorf("%q does not contain %q", result.HTML, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package render
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// Heading is an entry in the outline of a document. Anchor is the name of the
+// anchor GitHub gives the heading.
+type Heading struct {
+	Level  int    `json:"level"`
+	Anchor string `json:"anchor"`
+	Text   string `json:"text"`
+}
+
+// the paragraph a [TOC] marker is rendered as
+var tocMarker = regexp.MustCompile(`(?i)<p>\s*\[TOC\]\s*</p>`)
+
+// Outline returns the headings of an HTML fragment in the order they appear
+func Outline(fragment string) []Heading {
+	outline := make([]Heading, 0)
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(fragment))
+	var current *Heading
+	var text bytes.Buffer
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return outline
+		case xhtml.StartTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if current == nil && isHeading(name) {
+				current = &Heading{Level: int(name[1] - '0')}
+				text.Reset()
+				continue
+			}
+			if current == nil || string(name) != "a" {
+				continue
+			}
+			anchor, isAnchor := "", false
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = tokenizer.TagAttr()
+				switch string(key) {
+				case "name":
+					anchor = string(val)
+				case "class":
+					isAnchor = strings.Contains(" "+string(val)+" ", " anchor ")
+				}
+			}
+			if isAnchor && current.Anchor == "" {
+				current.Anchor = anchor
+			}
+		case xhtml.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if current != nil && isHeading(name) {
+				current.Text = strings.Join(strings.Fields(text.String()), " ")
+				outline = append(outline, *current)
+				current = nil
+			}
+		case xhtml.TextToken:
+			if current != nil {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// ExpandTOC replaces every [TOC] marker on a line of its own with a table of
+// contents built from the outline
+func ExpandTOC(fragment string, outline []Heading) string {
+	if !tocMarker.MatchString(fragment) {
+		return fragment
+	}
+	toc := TOC(outline)
+	return tocMarker.ReplaceAllLiteralString(fragment, toc)
+}
+
+// TOC returns a table of contents linking to the headings of an outline as
+// nested lists
+func TOC(outline []Heading) string {
+	var buf bytes.Buffer
+	buf.WriteString(`<nav class="toc">`)
+
+	// levels holds the heading level of every open list
+	levels := make([]int, 0)
+	for _, heading := range outline {
+		if heading.Anchor == "" {
+			continue
+		}
+		switch {
+		case len(levels) == 0 || heading.Level > levels[len(levels)-1]:
+			buf.WriteString("<ul>")
+			levels = append(levels, heading.Level)
+		default:
+			for len(levels) > 1 && heading.Level < levels[len(levels)-1] && heading.Level <= levels[len(levels)-2] {
+				buf.WriteString("</li></ul>")
+				levels = levels[:len(levels)-1]
+			}
+			buf.WriteString("</li>")
+		}
+		buf.WriteString(`<li><a href="#`)
+		buf.WriteString(html.EscapeString(heading.Anchor))
+		buf.WriteString(`">`)
+		buf.WriteString(html.EscapeString(heading.Text))
+		buf.WriteString("</a>")
+	}
+	for range levels {
+		buf.WriteString("</li></ul>")
+	}
+	buf.WriteString("</nav>")
+	return buf.String()
+}
@@ -0,0 +1,150 @@
+package render
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutline(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+		want     []Heading
+	}{
+		{
+			name:     "no headings",
+			fragment: "<p>text</p>",
+			want:     []Heading{},
+		},
+		{
+			name:     "anchor",
+			fragment: `<h2><a name="a-b" class="anchor" href="#a-b"><span class="octicon"></span></a>A  <em>b</em></h2>`,
+			want:     []Heading{{Level: 2, Anchor: "a-b", Text: "A b"}},
+		},
+		{
+			name:     "links are not anchors",
+			fragment: `<h3><a href="http://x" name="x">link</a> text</h3>`,
+			want:     []Heading{{Level: 3, Text: "link text"}},
+		},
+		{
+			name:     "first anchor",
+			fragment: `<h1><a name="one" class="anchor"></a><a name="two" class="x anchor y"></a>T</h1>`,
+			want:     []Heading{{Level: 1, Anchor: "one", Text: "T"}},
+		},
+		{
+			name:     "text is unescaped and its spaces collapsed",
+			fragment: "<h4>\n  a &amp; <code>b</code>\n</h4><p>not a heading</p><h6>c</h6>",
+			want:     []Heading{{Level: 4, Text: "a & b"}, {Level: 6, Text: "c"}},
+		},
+		{
+			name:     "unclosed heading",
+			fragment: "<h1>text",
+			want:     []Heading{},
+		},
+		{
+			name:     "headers are not headings",
+			fragment: "<header>x</header><hr>",
+			want:     []Heading{},
+		},
+	}
+	for _, test := range tests {
+		if got := Outline(test.fragment); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRenderOutline(t *testing.T) {
+	source := "# Title\n\nSetext *one*\n===\n\nSub two\n---\n\n### `code` & more\n\n    # indented code\n\n```\n# fenced code\n```\n"
+	result, err := NewGFM().Render([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Heading{
+		{Level: 1, Anchor: "title", Text: "Title"},
+		{Level: 1, Anchor: "setext-one", Text: "Setext one"},
+		{Level: 2, Anchor: "sub-two", Text: "Sub two"},
+		{Level: 3, Anchor: "code-more", Text: "code & more"},
+	}
+	if !reflect.DeepEqual(result.Outline, want) {
+		t.Errorf("got %+v, want %+v", result.Outline, want)
+	}
+	for _, heading := range want {
+		if !strings.Contains(result.HTML, `name="`+heading.Anchor+`"`) {
+			t.Errorf("anchor %q is not in %q", heading.Anchor, result.HTML)
+		}
+	}
+}
+
+func TestTOC(t *testing.T) {
+	tests := []struct {
+		name    string
+		outline []Heading
+		want    string
+	}{
+		{
+			name: "empty",
+			want: `<nav class="toc"></nav>`,
+		},
+		{
+			name:    "flat",
+			outline: []Heading{{1, "a", "A"}, {1, "b", "B"}},
+			want:    `<nav class="toc"><ul><li><a href="#a">A</a></li><li><a href="#b">B</a></li></ul></nav>`,
+		},
+		{
+			name:    "nested",
+			outline: []Heading{{1, "a", "A"}, {2, "b", "B"}, {3, "c", "C"}, {2, "d", "D"}, {1, "e", "E"}},
+			want: `<nav class="toc"><ul><li><a href="#a">A</a><ul><li><a href="#b">B</a><ul><li><a href="#c">C</a>` +
+				`</li></ul></li><li><a href="#d">D</a></li></ul></li><li><a href="#e">E</a></li></ul></nav>`,
+		},
+		{
+			name:    "skipped levels",
+			outline: []Heading{{2, "a", "A"}, {4, "b", "B"}, {3, "c", "C"}},
+			want:    `<nav class="toc"><ul><li><a href="#a">A</a><ul><li><a href="#b">B</a></li><li><a href="#c">C</a></li></ul></li></ul></nav>`,
+		},
+		{
+			name:    "starting deeper than later headings",
+			outline: []Heading{{3, "a", "A"}, {1, "b", "B"}},
+			want:    `<nav class="toc"><ul><li><a href="#a">A</a></li><li><a href="#b">B</a></li></ul></nav>`,
+		},
+		{
+			name:    "headings without anchors and escaping",
+			outline: []Heading{{1, "", "none"}, {1, `a"b`, "<x>"}},
+			want:    `<nav class="toc"><ul><li><a href="#a&#34;b">&lt;x&gt;</a></li></ul></nav>`,
+		},
+	}
+	for _, test := range tests {
+		if got := TOC(test.outline); got != test.want {
+			t.Errorf("%s:\n got %q\nwant %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExpandTOC(t *testing.T) {
+	outline := []Heading{{1, "a", "A"}}
+	toc := TOC(outline)
+	tests := []struct {
+		fragment, want string
+	}{
+		{"<p>[TOC]</p><h1>A</h1>", toc + "<h1>A</h1>"},
+		{"<p> [toc]\n</p>", toc},
+		{"<p>see [TOC] here</p>", "<p>see [TOC] here</p>"},
+		{"<pre><code>[TOC]</code></pre>", "<pre><code>[TOC]</code></pre>"},
+		{"<p>[TOC]</p><p>[TOC]</p>", toc + toc},
+	}
+	for _, test := range tests {
+		if got := ExpandTOC(test.fragment, outline); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.fragment, got, test.want)
+		}
+	}
+
+	result, err := NewGFM().Render([]byte("[TOC]\n\n# A\n\n## B\n\n`[TOC]`\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<nav class="toc"><ul><li><a href="#a">A</a><ul><li><a href="#b">B</a></li></ul></li></ul></nav>`
+	if !strings.HasPrefix(result.HTML, want) || !strings.Contains(result.HTML, "<code>[TOC]</code>") {
+		t.Errorf("got %q", result.HTML)
+	}
+}
@@ -0,0 +1,101 @@
+package render
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultName is the name the GitHub Flavored Markdown renderer is registered under
+const DefaultName = "gfm"
+
+// Registry keeps track of the available renderers and which one should be
+// used for a given document
+type Registry struct {
+	renderers  map[string]Renderer
+	extensions map[string]string
+	fallback   string
+	sync.RWMutex
+}
+
+// NewRegistry is the constructor for a registry with the GFM renderer as the default
+func NewRegistry() *Registry {
+	r := &Registry{
+		renderers:  make(map[string]Renderer),
+		extensions: make(map[string]string),
+		fallback:   DefaultName,
+	}
+	r.Register(DefaultName, NewGFM())
+	for _, ext := range []string{".md", ".markdown", ".mdown", ".mkd"} {
+		r.RegisterExtension(ext, DefaultName)
+	}
+	return r
+}
+
+// Register adds a renderer under a given name, replacing any previous one
+func (r *Registry) Register(name string, renderer Renderer) {
+	r.Lock()
+	defer r.Unlock()
+	r.renderers[name] = renderer
+}
+
+// RegisterExtension associates a file extension (ie: ".md") with a renderer name
+func (r *Registry) RegisterExtension(ext, name string) {
+	r.Lock()
+	defer r.Unlock()
+	r.extensions[normalizeExt(ext)] = name
+}
+
+// Handles reports whether a renderer is registered for the extension of path
+func (r *Registry) Handles(path string) bool {
+	r.RLock()
+	defer r.RUnlock()
+	_, ok := r.extensions[normalizeExt(filepath.Ext(path))]
+	return ok && filepath.Ext(path) != ""
+}
+
+// SetDefault changes the renderer used when nothing else matches
+func (r *Registry) SetDefault(name string) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.renderers[name]; !ok {
+		return fmt.Errorf("render error: unknown renderer: name=%q", name)
+	}
+	r.fallback = name
+	return nil
+}
+
+// Get returns the renderer registered under a name
+func (r *Registry) Get(name string) (Renderer, error) {
+	r.RLock()
+	defer r.RUnlock()
+	renderer, ok := r.renderers[name]
+	if !ok {
+		return nil, fmt.Errorf("render error: unknown renderer: name=%q", name)
+	}
+	return renderer, nil
+}
+
+// Select picks the renderer for a document. An explicit name takes precedence,
+// followed by the extension of the path and finally the default renderer.
+func (r *Registry) Select(name, path string) (Renderer, error) {
+	if name != "" {
+		return r.Get(name)
+	}
+	r.RLock()
+	fallback := r.fallback
+	if byExt, ok := r.extensions[normalizeExt(filepath.Ext(path))]; ok && path != "" {
+		fallback = byExt
+	}
+	r.RUnlock()
+	return r.Get(fallback)
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
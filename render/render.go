@@ -0,0 +1,64 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Result holds a rendered markdown document
+type Result struct {
+	HTML    string                 `json:"html"`
+	Title   string                 `json:"title"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Outline []Heading              `json:"outline,omitempty"`
+	Lines   []LineRange            `json:"lines,omitempty"`
+}
+
+// A Renderer converts markdown data into HTML
+type Renderer interface {
+	Render(data []byte) (*Result, error)
+}
+
+// RendererFunc is an adapter to allow for functions to be used as renderers
+type RendererFunc func(data []byte) (*Result, error)
+
+// Render calls the renderer func
+func (f RendererFunc) Render(data []byte) (*Result, error) {
+	return f(data)
+}
+
+// Title returns the text of the first heading in an HTML fragment
+func Title(fragment []byte) string {
+	tokenizer := html.NewTokenizer(bytes.NewReader(fragment))
+	depth := 0
+	var title []string
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(strings.Join(title, ""))
+		case html.StartTagToken:
+			if depth > 0 {
+				continue
+			}
+			name, _ := tokenizer.TagName()
+			if isHeading(name) {
+				depth = 1
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if depth > 0 && isHeading(name) {
+				return strings.TrimSpace(strings.Join(title, ""))
+			}
+		case html.TextToken:
+			if depth > 0 {
+				title = append(title, string(tokenizer.Text()))
+			}
+		}
+	}
+}
+
+func isHeading(name []byte) bool {
+	return len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6'
+}
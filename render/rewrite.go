@@ -0,0 +1,71 @@
+package render
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// URLRewriter returns the replacement for a url found in the attribute of an
+// element. Returning the url unchanged leaves the element untouched.
+type URLRewriter func(attr, u string) string
+
+// RewriteURLs runs the rewriter over every href and src attribute in an HTML fragment
+func RewriteURLs(fragment string, rewrite URLRewriter) string {
+	var out bytes.Buffer
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			return out.String()
+		}
+		raw := tokenizer.Raw()
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(raw)
+			continue
+		}
+
+		// copy the raw bytes since building the token reuses the buffer
+		raw = append([]byte(nil), raw...)
+		token := tokenizer.Token()
+		changed := false
+		for i, attr := range token.Attr {
+			if attr.Key != "href" && attr.Key != "src" {
+				continue
+			}
+			if u := rewrite(attr.Key, attr.Val); u != attr.Val {
+				token.Attr[i].Val = u
+				changed = true
+			}
+		}
+		if changed {
+			out.WriteString(token.String())
+		} else {
+			out.Write(raw)
+		}
+	}
+}
+
+// IsRelative reports whether a url points to a path relative to the document
+func IsRelative(u string) bool {
+	if u == "" || strings.HasPrefix(u, "#") || strings.HasPrefix(u, "/") {
+		return false
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "" && parsed.Host == "" && parsed.Path != ""
+}
+
+// RewriteRelative prefixes every relative url in an HTML fragment with base
+func RewriteRelative(fragment, base string) string {
+	return RewriteURLs(fragment, func(attr, u string) string {
+		if !IsRelative(u) {
+			return u
+		}
+		return base + strings.TrimPrefix(u, "./")
+	})
+}
@@ -2,42 +2,33 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/davinche/godown/dispatch"
-	"github.com/kardianos/osext"
+	"github.com/davinche/godown/pages"
 )
 
-var templates *template.Template
-
-func init() {
-	// parse the html template
-	binDir, err := osext.ExecutableFolder()
-	if err != nil {
-		fmt.Println("error: could not determine binary folder")
-		os.Exit(1)
-	}
-	templates = template.Must(template.ParseFiles(binDir + "/index.html"))
-}
-
 // API is the server that processes user commands
 type API struct {
 	prefix     string
 	port       int
 	dispatcher *dispatch.Dispatcher
+	auth       *Auth
 }
 
 // NewAPI is the constructor for a new api server
-func NewAPI(d *dispatch.Dispatcher) *API {
+func NewAPI(d *dispatch.Dispatcher, auth *Auth) *API {
 	return &API{
 		dispatcher: d,
+		auth:       auth,
 	}
 }
 
@@ -48,25 +39,52 @@ func (a *API) Serve(prefix string, port int) {
 	http.HandleFunc(prefix, a.serve)
 }
 
-func decodeFilePath(r io.Reader) (string, error) {
-	s := struct{ Path string }{}
+// FileRequest is the body of a request to preview a file
+type FileRequest struct {
+	Path     string
+	Renderer string `json:",omitempty"`
+}
+
+// EditRequest is the body of a request to edit the text of an in-memory file
+type EditRequest struct {
+	Base  int             `json:"base"`
+	Edits []dispatch.Edit `json:"edits"`
+}
+
+// VersionResponse tells the sender of an in-memory file which version of its
+// text the server has, which edits have to be based on
+type VersionResponse struct {
+	Version int `json:"version"`
+}
+
+func decodeFileRequest(r io.Reader) (*FileRequest, error) {
+	s := &FileRequest{}
 	decoder := json.NewDecoder(r)
-	if err := decoder.Decode(&s); err != nil {
-		return "", err
+	if err := decoder.Decode(s); err != nil {
+		return nil, err
 	}
-	return s.Path, nil
+	return s, nil
 }
 
 func (a *API) serve(w http.ResponseWriter, r *http.Request) {
+	// Only the CLI, which can read the session secret, may change what is previewed
+	if r.Method != "GET" && r.Method != "HEAD" && !a.auth.Authorized(r) {
+		http.Error(w, "missing or invalid session secret", http.StatusUnauthorized)
+		return
+	}
+
 	// Are we adding a new file?
 	if r.Method == "POST" {
 		defer r.Body.Close()
-		filePath, err := decodeFilePath(r.Body)
+		fileRequest, err := decodeFileRequest(r.Body)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		done, errCh := a.dispatcher.Dispatch("FILE_ADD", filePath)
+		done, errCh := a.dispatcher.Dispatch(&dispatch.FileAddEvent{
+			Path:     fileRequest.Path,
+			Renderer: fileRequest.Renderer,
+		})
 		select {
 		case <-done:
 			w.WriteHeader(http.StatusOK)
@@ -78,19 +96,24 @@ func (a *API) serve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.FormValue("id")
+	// the body of a request is its data, never a form
+	query := r.URL.Query()
+	id := query.Get("id")
 	if r.Method == "DELETE" {
 		// shutdown the server
 		if id == "" {
-			done, _ := a.dispatcher.Dispatch("SHUTDOWN", "")
+			done, _ := a.dispatcher.Dispatch(&dispatch.ShutdownEvent{})
 			<-done
 			w.WriteHeader(http.StatusOK)
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
 			os.Exit(0)
 			return
 		}
 
 		// delete file from tracking
-		done, errCh := a.dispatcher.Dispatch("FILE_DELETE", id)
+		done, errCh := a.dispatcher.Dispatch(&dispatch.FileDeleteEvent{Path: id})
 		select {
 		case <-done:
 			w.WriteHeader(http.StatusOK)
@@ -110,46 +133,171 @@ func (a *API) serve(w http.ResponseWriter, r *http.Request) {
 		}
 
 		defer r.Body.Close()
+
+		// editors stream their buffers as a series of documents
+		if format := query.Get("follow"); format != "" {
+			a.follow(w, r, id, format)
+			return
+		}
+
+		history, err := historyDepth(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		data, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "could not read body data", http.StatusBadRequest)
 			return
 		}
 
-		done, errCh := a.dispatcher.Dispatch("MEM_ADD", &struct {
-			ID   string
-			Data []byte
-		}{
-			ID:   id,
-			Data: data,
-		})
+		reply := make(chan int, 1)
+		event := &dispatch.MemAddEvent{
+			ID:       id,
+			Data:     data,
+			Renderer: query.Get("renderer"),
+			History:  history,
+			Reply:    reply,
+		}
+		switch version, err := a.awaitVersion(event, reply); err {
+		case nil:
+			writeVersion(w, http.StatusOK, version)
+		case errTimeout:
+			w.WriteHeader(http.StatusRequestTimeout)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
 
-		select {
-		case <-done:
-			w.WriteHeader(http.StatusOK)
-		case err := <-errCh:
+	// Edit in memory data
+	if r.Method == "PATCH" {
+		if id == "" {
+			http.Error(w, "unique identifier for the markdown file required", http.StatusBadRequest)
+			return
+		}
+
+		defer r.Body.Close()
+		editRequest := &EditRequest{}
+		if err := json.NewDecoder(r.Body).Decode(editRequest); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-		case <-time.After(5 * time.Second):
+			return
+		}
+
+		reply := make(chan int, 1)
+		event := &dispatch.MemEditEvent{
+			ID:    id,
+			Base:  editRequest.Base,
+			Edits: editRequest.Edits,
+			Reply: reply,
+		}
+		version, err := a.awaitVersion(event, reply)
+		if conflict, ok := err.(*dispatch.ConflictError); ok {
+			// stale edits are answered with the version to send in full
+			writeVersion(w, http.StatusConflict, conflict.Version)
+			return
+		}
+		switch err {
+		case nil:
+			writeVersion(w, http.StatusOK, version)
+		case errTimeout:
 			w.WriteHeader(http.StatusRequestTimeout)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		}
 		return
 	}
 
 	// Render the HTML Page from the browser if get request
 	if id == "" {
-		w.WriteHeader(http.StatusNotFound)
+		a.serveDashboard(w, r)
 		return
 	}
-	// serving the file
-	var host string
-	host, _, err := net.SplitHostPort(r.Host)
-	if err != nil {
-		host = "localhost"
+	if !a.auth.CanView(w, r) {
+		http.Error(w, "missing or invalid preview token", http.StatusUnauthorized)
+		return
 	}
+	// serving the file
+	assets, templates := pages.Assets()
 	tStruct := struct {
-		Host   string
-		Port   int
-		FileID string
-	}{host, a.port, id}
+		FileID  string
+		Theme   string
+		KaTeX   bool
+		Mermaid bool
+	}{id, pages.Theme(), pages.HasKaTeX(assets), pages.HasMermaid(assets)}
 	templates.ExecuteTemplate(w, "index.html", tStruct)
 }
+
+// errNoReply is returned by awaitVersion when an event is served without a version
+var errNoReply = errors.New("api error: the event was served without a version")
+
+// errTimeout is returned by await when an event takes too long to be served
+var errTimeout = errors.New("api error: timed out waiting for the event to be served")
+
+// await dispatches an event and waits for it to be served. Errors take
+// precedence over the event being done, since both are reported when a
+// handler fails.
+func (a *API) await(e dispatch.Event) error {
+	done, errCh := a.dispatcher.Dispatch(e)
+	select {
+	case err := <-errCh:
+		return err
+	case <-done:
+		select {
+		case err := <-errCh:
+			return err
+		default:
+			return nil
+		}
+	case <-time.After(5 * time.Second):
+		return errTimeout
+	}
+}
+
+// awaitVersion waits for an event that changes an in-memory file to be served
+// and returns the new version of the file's text, which is sent on reply
+func (a *API) awaitVersion(e dispatch.Event, reply <-chan int) (int, error) {
+	if err := a.await(e); err != nil {
+		return 0, err
+	}
+	select {
+	case version := <-reply:
+		return version, nil
+	default:
+		return 0, errNoReply
+	}
+}
+
+// historyDepth reads how many earlier versions of an in-memory file to keep
+// from a request, which is nil when the request does not say
+func historyDepth(query url.Values) (*int, error) {
+	value := query.Get("history")
+	if value == "" {
+		return nil, nil
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 0 {
+		return nil, fmt.Errorf("invalid history depth: %q", value)
+	}
+	return &depth, nil
+}
+
+func writeVersion(w http.ResponseWriter, status int, version int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&VersionResponse{Version: version})
+}
+
+// serveDashboard renders the page listing every preview
+func (a *API) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != a.prefix {
+		http.NotFound(w, r)
+		return
+	}
+	if !a.auth.CanView(w, r) {
+		http.Error(w, "missing or invalid preview token", http.StatusUnauthorized)
+		return
+	}
+	_, templates := pages.Assets()
+	templates.ExecuteTemplate(w, "dashboard.html", nil)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/davinche/godown/internal/paths"
+)
+
+// DocumentsPrefix is the url prefix for per-document resources
+const DocumentsPrefix = "/doc/"
+
+// AssetsPath is the path segment that follows the document id in asset urls
+const AssetsPath = "/assets/"
+
+var errOutsideRoot = errors.New("path is outside of the document root")
+
+// AssetResolver finds the directory a document's relative assets are served from
+type AssetResolver interface {
+	AssetRoot(id string) (string, error)
+}
+
+// Assets serves the files that live next to a previewed document
+type Assets struct {
+	prefix   string
+	resolver AssetResolver
+	auth     *Auth
+}
+
+// NewAssets is the constructor for the document assets server
+func NewAssets(r AssetResolver, auth *Auth) *Assets {
+	return &Assets{
+		resolver: r,
+		auth:     auth,
+	}
+}
+
+// AssetsURL returns the url prefix a document's relative assets are served under
+func AssetsURL(id string) string {
+	return DocumentsPrefix + id + AssetsPath
+}
+
+// Serve registers the assets server with the http defaultmux
+func (a *Assets) Serve(prefix string) {
+	a.prefix = prefix
+	http.HandleFunc(prefix, a.serve)
+}
+
+func (a *Assets) serve(w http.ResponseWriter, r *http.Request) {
+	if !a.auth.CanView(w, r) {
+		http.Error(w, "missing or invalid preview token", http.StatusUnauthorized)
+		return
+	}
+
+	// the url is of the form <prefix><id>/assets/<path>
+	rest := strings.TrimPrefix(r.URL.Path, a.prefix)
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || !strings.HasPrefix(rest[slash:], AssetsPath) {
+		http.NotFound(w, r)
+		return
+	}
+	id := rest[:slash]
+	name := rest[slash+len(AssetsPath):]
+
+	root, err := a.resolver.AssetRoot(id)
+	if err != nil {
+		log.Printf("assets warning: %v\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	filePath, err := resolveAsset(root, name)
+	if err != nil {
+		log.Printf("assets error: refusing to serve asset: id=%q; path=%q; err=%q\n", id, name, err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+}
+
+// resolveAsset joins the requested name onto root and makes sure the result,
+// including any symlinks along the way, does not escape root
+func resolveAsset(root, name string) (string, error) {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	joined := filepath.Join(root, filepath.FromSlash(path.Clean("/"+name)))
+	resolved, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		// let missing files fall through to a 404
+		if os.IsNotExist(err) {
+			return joined, nil
+		}
+		return "", err
+	}
+	if !paths.IsBelow(root, resolved) {
+		return "", errOutsideRoot
+	}
+	return resolved, nil
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ViewCookie is the cookie that lets a browser keep viewing previews after
+// opening a preview url containing the view token
+const ViewCookie = "godown_view"
+
+// Auth guards the daemon from other processes and web pages. The control API
+// requires the session secret, which only the user can read from the runtime
+// directory. Browsers are handed a token derived from the secret that only
+// allows viewing previews.
+type Auth struct {
+	secret string
+	public bool
+}
+
+// NewAuth is the constructor for the daemon's authentication. A public daemon
+// accepts requests for any host name instead of loopback names only.
+func NewAuth(secret string, public bool) *Auth {
+	return &Auth{
+		secret: secret,
+		public: public,
+	}
+}
+
+// NewSecret generates a random session secret
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ViewToken derives the token that allows browsers to view previews
+func ViewToken(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("view"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SecretPath returns the file the secret of the daemon on a port is kept in
+func SecretPath(port int) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "godown-"+strconv.Itoa(os.Getuid()))
+	} else {
+		dir = filepath.Join(dir, "godown")
+	}
+	return filepath.Join(dir, strconv.Itoa(port)+".secret")
+}
+
+// WriteSecret stores the secret of the daemon on a port so only the current
+// user can read it, along with the address the daemon is bound to so that the
+// CLI helpers can find it
+func WriteSecret(port int, secret, bind string) error {
+	path := SecretPath(port)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// refuse a directory that someone else could read or replace
+	stat, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS != "windows" && stat.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("auth error: runtime directory is not private: path=%q", filepath.Dir(path))
+	}
+	os.Remove(path)
+	return ioutil.WriteFile(path, []byte(secret+"\n"+bind+"\n"), 0600)
+}
+
+// ReadSecret returns the secret of the daemon on a port
+func ReadSecret(port int) (string, error) {
+	lines, err := readSecretFile(port)
+	if err != nil {
+		return "", err
+	}
+	return lines[0], nil
+}
+
+// ReadBind returns the address the daemon on a port is bound to
+func ReadBind(port int) (string, error) {
+	lines, err := readSecretFile(port)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) < 2 || lines[1] == "" {
+		return "", fmt.Errorf("auth error: no bind address in the secret file: path=%q", SecretPath(port))
+	}
+	return lines[1], nil
+}
+
+// readSecretFile returns the lines of the secret file of the daemon on a port
+func readSecretFile(port int) ([]string, error) {
+	data, err := ioutil.ReadFile(SecretPath(port))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n"), nil
+}
+
+// RemoveSecret deletes the secret of the daemon on a port
+func RemoveSecret(port int) {
+	os.Remove(SecretPath(port))
+}
+
+// Authorized reports whether a request carries the session secret
+func (a *Auth) Authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return equal(strings.TrimPrefix(header, "Bearer "), a.secret)
+}
+
+// CanView reports whether a request may view previews, either with the view
+// token in the query string or the cookie, or with the session secret. A view
+// token in the query string is remembered in a cookie.
+func (a *Auth) CanView(w http.ResponseWriter, r *http.Request) bool {
+	if a.Authorized(r) {
+		return true
+	}
+	token := ViewToken(a.secret)
+	if equal(r.URL.Query().Get("token"), token) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     ViewCookie,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		return true
+	}
+	cookie, err := r.Cookie(ViewCookie)
+	return err == nil && equal(cookie.Value, token)
+}
+
+// CheckHost rejects requests whose Host header does not name this machine,
+// which protects a loopback daemon from DNS rebinding
+func (a *Auth) CheckHost(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.public && !isLoopbackHost(r.Host) {
+			log.Printf("auth error: rejecting request for foreign host: host=%q\n", r.Host)
+			http.Error(w, "invalid host", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// CheckOrigin reports whether a websocket upgrade comes from one of our own pages
+func (a *Auth) CheckOrigin(r *http.Request) bool {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host == "" {
+		return false
+	}
+	return strings.EqualFold(origin.Host, r.Host)
+}
+
+func isLoopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
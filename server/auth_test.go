@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSecretFile(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	if _, err := ReadBind(1337); err == nil {
+		t.Fatal("read the bind address of a daemon that is not running")
+	}
+	if err := WriteSecret(1337, "secret", "::1"); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := ReadSecret(1337); err != nil || secret != "secret" {
+		t.Errorf("got secret %q, %v", secret, err)
+	}
+	if bind, err := ReadBind(1337); err != nil || bind != "::1" {
+		t.Errorf("got bind address %q, %v", bind, err)
+	}
+	if _, err := ReadBind(1338); err == nil {
+		t.Error("read the bind address of a daemon on another port")
+	}
+	RemoveSecret(1337)
+	if _, err := ReadSecret(1337); err == nil {
+		t.Error("read a removed secret")
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/davinche/godown/dispatch"
+)
+
+// Kinds of sources a previewed document can come from
+const (
+	SourceFile      = "file"
+	SourceMemory    = "memory"
+	SourceDirectory = "directory"
+)
+
+// DocumentInfo describes a previewed document
+type DocumentInfo struct {
+	ID      string                 `json:"id"`
+	Source  string                 `json:"source"`
+	Name    string                 `json:"name"`
+	Title   string                 `json:"title"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Updated time.Time              `json:"updated"`
+	Clients int                    `json:"clients"`
+}
+
+// Status describes a running daemon. Uptime is in seconds.
+type Status struct {
+	Running   bool           `json:"running"`
+	Version   string         `json:"version"`
+	Addr      string         `json:"addr"`
+	Port      int            `json:"port"`
+	Started   time.Time      `json:"started"`
+	Uptime    float64        `json:"uptime"`
+	Documents []DocumentInfo `json:"documents"`
+}
+
+// DocumentLister lists every document that is being previewed
+type DocumentLister interface {
+	Documents() []DocumentInfo
+}
+
+// DashboardMessage is a message sent by the dashboard to the server
+type DashboardMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// dashboardUpdate is the list of documents sent to the dashboard
+type dashboardUpdate struct {
+	Type      string         `json:"type"`
+	Documents []DocumentInfo `json:"documents"`
+}
+
+// how long a dashboard gets to receive an update before it is dropped
+const dashboardTimeout = 5 * time.Second
+
+// Dashboard keeps the browsers showing the list of previews up to date
+type Dashboard struct {
+	dispatcher *dispatch.Dispatcher
+	lister     DocumentLister
+	auth       *Auth
+	clients    map[*websocket.Conn]struct{}
+	sync.Mutex
+}
+
+// NewDashboard is the constructor for the dashboard server
+func NewDashboard(d *dispatch.Dispatcher, lister DocumentLister, auth *Auth) *Dashboard {
+	return &Dashboard{
+		dispatcher: d,
+		lister:     lister,
+		auth:       auth,
+		clients:    make(map[*websocket.Conn]struct{}),
+	}
+}
+
+// EventTypes are the dispatched events the dashboard handles
+func (s *Dashboard) EventTypes() []dispatch.EventType {
+	return []dispatch.EventType{
+		dispatch.DocChange,
+		dispatch.Shutdown,
+	}
+}
+
+// ServeEvent sends the new list of documents whenever one of them changes
+func (s *Dashboard) ServeEvent(e dispatch.Event) error {
+	switch e.(type) {
+	case *dispatch.DocChangeEvent:
+		s.broadcast()
+	case *dispatch.ShutdownEvent:
+		s.Lock()
+		for client := range s.clients {
+			client.Close()
+		}
+		s.clients = make(map[*websocket.Conn]struct{})
+		s.Unlock()
+	}
+	return nil
+}
+
+// Wait is noop for the dashboard
+func (s *Dashboard) Wait() {}
+
+// Serve registers the dashboard's websocket with the http defaultmux
+func (s *Dashboard) Serve(prefix string) {
+	http.HandleFunc(prefix, s.serve)
+}
+
+func (s *Dashboard) serve(w http.ResponseWriter, r *http.Request) {
+	if !s.auth.CanView(w, r) {
+		http.Error(w, "missing or invalid preview token", http.StatusUnauthorized)
+		return
+	}
+	handleWS := func(ws *websocket.Conn) {
+		s.Lock()
+		s.clients[ws] = struct{}{}
+		s.Unlock()
+		s.sendTo(ws, s.update())
+
+		for {
+			var msg DashboardMessage
+			err := websocket.JSON.Receive(ws, &msg)
+			if err != nil {
+				switch err.(type) {
+				case *json.SyntaxError, *json.UnmarshalTypeError:
+					continue
+				}
+				s.Lock()
+				delete(s.clients, ws)
+				s.Unlock()
+				return
+			}
+
+			switch msg.Type {
+			case "stop":
+				s.stop(msg.ID)
+			}
+		}
+	}
+	server := websocket.Server{
+		Handler: handleWS,
+		// only our own pages may connect, which also keeps other sites from
+		// stopping previews
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if !s.auth.CheckOrigin(r) {
+				return fmt.Errorf("dashboard error: invalid origin: origin=%q; host=%q", r.Header.Get("Origin"), r.Host)
+			}
+			return nil
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// stop ends the preview of a document by its id
+func (s *Dashboard) stop(id string) {
+	for _, doc := range s.lister.Documents() {
+		if doc.ID == id {
+			log.Printf("dashboard status: stopping preview: id=%q; name=%q\n", id, doc.Name)
+			s.dispatcher.Dispatch(&dispatch.FileDeleteEvent{Path: doc.Name})
+			return
+		}
+	}
+	log.Printf("dashboard warning: cannot stop unknown document: id=%q\n", id)
+}
+
+func (s *Dashboard) update() dashboardUpdate {
+	return dashboardUpdate{
+		Type:      "documents",
+		Documents: s.lister.Documents(),
+	}
+}
+
+// broadcast sends the list of documents to every dashboard
+func (s *Dashboard) broadcast() {
+	s.Lock()
+	clients := make([]*websocket.Conn, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.Unlock()
+	if len(clients) == 0 {
+		return
+	}
+
+	update := s.update()
+	for _, client := range clients {
+		s.sendTo(client, update)
+	}
+}
+
+func (s *Dashboard) sendTo(ws *websocket.Conn, update dashboardUpdate) {
+	ws.SetWriteDeadline(time.Now().Add(dashboardTimeout))
+	if err := websocket.JSON.Send(ws, update); err != nil {
+		log.Printf("dashboard warning: dropping client: err=%q\n", err)
+		s.Lock()
+		delete(s.clients, ws)
+		s.Unlock()
+		ws.Close()
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/davinche/godown/dispatch"
+	"github.com/davinche/godown/render"
+)
+
+// how many jumps an editor can fall behind on before they are dropped
+const editorBacklog = 16
+
+// Jump asks an editor to show a line of a document's source. Name is the
+// path of a file, or the id an in-memory file was sent with.
+type Jump struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Name   string `json:"name"`
+	Line   int    `json:"line"`
+}
+
+// Editor sends the source lines that are double-clicked in previews back to
+// the editors. Editors either subscribe to a stream of jumps, or are told with
+// a command in which {file}, {line} and {id} are replaced, ie:
+//
+//	nvim --server /tmp/nvim.sock --remote-send ":e +{line} {file}<CR>"
+type Editor struct {
+	lister  DocumentLister
+	auth    *Auth
+	command []string
+	streams map[chan Jump]struct{}
+	sync.Mutex
+}
+
+// NewEditor is the constructor for the editor server. An empty command only
+// streams the jumps.
+func NewEditor(lister DocumentLister, auth *Auth, command string) *Editor {
+	return &Editor{
+		lister:  lister,
+		auth:    auth,
+		command: render.SplitCommand(command),
+		streams: make(map[chan Jump]struct{}),
+	}
+}
+
+// EventTypes are the dispatched events the editor handles
+func (s *Editor) EventTypes() []dispatch.EventType {
+	return []dispatch.EventType{
+		dispatch.Jump,
+		dispatch.Shutdown,
+	}
+}
+
+// ServeEvent passes the jumps on to the editors
+func (s *Editor) ServeEvent(e dispatch.Event) error {
+	switch e := e.(type) {
+	case *dispatch.JumpEvent:
+		s.jump(e)
+	case *dispatch.ShutdownEvent:
+		s.Lock()
+		for stream := range s.streams {
+			close(stream)
+		}
+		s.streams = make(map[chan Jump]struct{})
+		s.Unlock()
+	}
+	return nil
+}
+
+// Wait is noop for the editor
+func (s *Editor) Wait() {}
+
+// Serve registers the stream of jumps with the http defaultmux
+func (s *Editor) Serve(prefix string) {
+	http.HandleFunc(prefix, s.serve)
+}
+
+// serve streams the jumps as server-sent events. Only the CLI and editors,
+// which can read the session secret, may follow them.
+func (s *Editor) serve(w http.ResponseWriter, r *http.Request) {
+	if !s.auth.Authorized(r) {
+		http.Error(w, "missing or invalid session secret", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream := make(chan Jump, editorBacklog)
+	s.Lock()
+	s.streams[stream] = struct{}{}
+	s.Unlock()
+	defer s.unsubscribe(stream)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case jump, ok := <-stream:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(jump)
+			fmt.Fprintf(w, "event: jump\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Editor) unsubscribe(stream chan Jump) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.streams[stream]; ok {
+		delete(s.streams, stream)
+		close(stream)
+	}
+}
+
+// jump tells every editor to show a line of a document
+func (s *Editor) jump(e *dispatch.JumpEvent) {
+	var jump *Jump
+	for _, doc := range s.lister.Documents() {
+		if doc.ID == e.ID {
+			jump = &Jump{ID: doc.ID, Source: doc.Source, Name: doc.Name, Line: e.Line}
+		}
+	}
+	if jump == nil {
+		log.Printf("editor warning: cannot jump to unknown document: id=%q\n", e.ID)
+		return
+	}
+	log.Printf("editor status: jumping to source: name=%q; line=%d\n", jump.Name, jump.Line)
+
+	s.Lock()
+	for stream := range s.streams {
+		select {
+		case stream <- *jump:
+		default:
+			log.Printf("editor warning: dropping jump for a slow editor: name=%q\n", jump.Name)
+		}
+	}
+	s.Unlock()
+
+	if len(s.command) > 0 {
+		go s.run(jump)
+	}
+}
+
+// run runs the editor command for a jump
+func (s *Editor) run(jump *Jump) {
+	replacer := strings.NewReplacer("{file}", jump.Name, "{line}", strconv.Itoa(jump.Line), "{id}", jump.ID)
+	args := make([]string, len(s.command))
+	for i, arg := range s.command {
+		args[i] = replacer.Replace(arg)
+	}
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		log.Printf("editor error: editor command failed: args=%q; err=%q; output=%q\n", args, err, out)
+	}
+}
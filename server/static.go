@@ -1,25 +1,12 @@
 package server
 
 import (
-	"log"
+	"io/fs"
 	"net/http"
-	"os"
 
-	"github.com/kardianos/osext"
+	"github.com/davinche/godown/pages"
 )
 
-var binDir string
-
-func init() {
-	// parse the html template
-	d, err := osext.ExecutableFolder()
-	if err != nil {
-		log.Println("error: could not determine binary folder")
-		os.Exit(1)
-	}
-	binDir = d
-}
-
 // Static is the static files server
 type Static struct{}
 
@@ -30,6 +17,15 @@ func NewStatic() *Static {
 
 // Serve registers the static server with the http defaultmux
 func (s *Static) Serve(prefix string) {
-	static := http.FileServer(http.Dir(binDir + "/static"))
-	http.Handle(prefix, http.StripPrefix(prefix, static))
+	http.Handle(prefix, http.StripPrefix(prefix, http.HandlerFunc(s.serve)))
+}
+
+func (s *Static) serve(w http.ResponseWriter, r *http.Request) {
+	assets, _ := pages.Assets()
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.FileServer(http.FS(static)).ServeHTTP(w, r)
 }
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/davinche/godown/dispatch"
+)
+
+// The ways documents can be framed in a followed stream
+const (
+	// FrameNUL separates whole documents with NUL bytes
+	FrameNUL = "nul"
+
+	// FrameJSON sends one StreamFrame as JSON per line
+	FrameJSON = "json"
+)
+
+// StreamFrame is a document, or edits to the last one, in a stream of
+// line-delimited JSON. Frames with edits are applied like an EditRequest.
+type StreamFrame struct {
+	Text  string          `json:"text,omitempty"`
+	Base  int             `json:"base,omitempty"`
+	Edits []dispatch.Edit `json:"edits,omitempty"`
+}
+
+// ReadFrames calls fn with every frame of a framed stream as soon as it has
+// arrived. A NUL stream may leave off the NUL after its last document.
+func ReadFrames(r io.Reader, format string, fn func(*StreamFrame) error) error {
+	switch format {
+	case FrameNUL:
+		reader := bufio.NewReader(r)
+		for {
+			data, err := reader.ReadBytes(0)
+			if err == io.EOF {
+				if len(data) > 0 {
+					return fn(&StreamFrame{Text: string(data)})
+				}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(&StreamFrame{Text: string(data[:len(data)-1])}); err != nil {
+				return err
+			}
+		}
+	case FrameJSON:
+		decoder := json.NewDecoder(r)
+		for {
+			var frame StreamFrame
+			err := decoder.Decode(&frame)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("malformed frame: %v", err)
+			}
+			if err := fn(&frame); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("unknown frame format: %q", format)
+}
+
+// follow renders every frame of a request body as it arrives. The response is
+// written while the body is still being read, one line per frame: "ok" and the
+// new version of the text for every frame rendered, or "stale" and the current
+// version for edits that have to be sent in full again. A line starting with
+// "error: " tells why the stream ended early.
+func (a *API) follow(w http.ResponseWriter, r *http.Request, id, format string) {
+	if format != FrameNUL && format != FrameJSON {
+		http.Error(w, fmt.Sprintf("unknown frame format: %q", format), http.StatusBadRequest)
+		return
+	}
+	history, err := historyDepth(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	controller := http.NewResponseController(w)
+	if err := controller.EnableFullDuplex(); err != nil {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	log.Printf("api status: following stream: id=%q; format=%q\n", id, format)
+	renderer := r.URL.Query().Get("renderer")
+	frames := 0
+	err = ReadFrames(r.Body, format, func(frame *StreamFrame) error {
+		reply := make(chan int, 1)
+		var event dispatch.Event
+		if frame.Edits != nil {
+			event = &dispatch.MemEditEvent{ID: id, Base: frame.Base, Edits: frame.Edits, Reply: reply}
+		} else {
+			event = &dispatch.MemAddEvent{ID: id, Data: []byte(frame.Text), Renderer: renderer, History: history, Reply: reply}
+		}
+		version, err := a.awaitVersion(event, reply)
+		if conflict, ok := err.(*dispatch.ConflictError); ok {
+			fmt.Fprintf(w, "stale %d\n", conflict.Version)
+			return controller.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		frames++
+		fmt.Fprintf(w, "ok %d\n", version)
+		return controller.Flush()
+	})
+	if err != nil {
+		log.Printf("api error: stream ended: id=%q; frames=%d; err=%q\n", id, frames, err)
+		fmt.Fprintf(w, "error: %v\n", err)
+		return
+	}
+	log.Printf("api status: stream ended: id=%q; frames=%d\n", id, frames)
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"golang.org/x/net/websocket"
@@ -12,19 +14,21 @@ import (
 type Websocket struct {
 	port       int
 	dispatcher *dispatch.Dispatcher
+	auth       *Auth
 }
 
-// WebsocketRequest encapsulates the websocket client and it's corresponding
-// resource it is trying to access
-type WebsocketRequest struct {
-	ID string
-	WS *websocket.Conn
+// WebsocketMessage is a message sent by a browser to the server
+type WebsocketMessage struct {
+	Type string `json:"type"`
+	// Line is the source line of a "cursor" message, counting from 1
+	Line int `json:"line,omitempty"`
 }
 
 // NewWebsocket is the constructor fot a new websocket server
-func NewWebsocket(d *dispatch.Dispatcher) *Websocket {
+func NewWebsocket(d *dispatch.Dispatcher, auth *Auth) *Websocket {
 	return &Websocket{
 		dispatcher: d,
+		auth:       auth,
 	}
 }
 
@@ -40,20 +44,55 @@ func (s *Websocket) serve(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing id in query string", http.StatusBadRequest)
 		return
 	}
+	if !s.auth.CanView(w, r) {
+		http.Error(w, "missing or invalid preview token", http.StatusUnauthorized)
+		return
+	}
 	handleWS := func(ws *websocket.Conn) {
-		request := &WebsocketRequest{
+		client := &dispatch.Client{
 			ID: id,
 			WS: ws,
 		}
-		s.dispatcher.Dispatch("ADD_WSCLIENT", request)
+		s.dispatcher.Dispatch(&dispatch.AddClientEvent{Client: client})
 		for {
-			var msg string
-			err := websocket.Message.Receive(ws, &msg)
+			var msg WebsocketMessage
+			err := websocket.JSON.Receive(ws, &msg)
 			if err != nil {
-				s.dispatcher.Dispatch("DEL_WSCLIENT", request)
+				// ignore malformed messages but stop on closed connections
+				switch err.(type) {
+				case *json.SyntaxError, *json.UnmarshalTypeError:
+					continue
+				}
+				s.dispatcher.Dispatch(&dispatch.DelClientEvent{Client: client})
 				return
 			}
+
+			switch msg.Type {
+			case "resync":
+				// the client missed an update and needs the full render
+				s.dispatcher.Dispatch(&dispatch.ResyncClientEvent{Client: client})
+			case "cursor":
+				// an editor connected to the preview moved its cursor
+				if msg.Line > 0 {
+					s.dispatcher.Dispatch(&dispatch.CursorEvent{ID: id, Line: msg.Line})
+				}
+			case "jump":
+				// a block of the preview was double-clicked
+				if msg.Line > 0 {
+					s.dispatcher.Dispatch(&dispatch.JumpEvent{ID: id, Line: msg.Line})
+				}
+			}
 		}
 	}
-	websocket.Handler(handleWS).ServeHTTP(w, r)
+	server := websocket.Server{
+		Handler: handleWS,
+		// only our own pages may connect
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if !s.auth.CheckOrigin(r) {
+				return fmt.Errorf("websocket error: invalid origin: origin=%q; host=%q", r.Header.Get("Origin"), r.Host)
+			}
+			return nil
+		},
+	}
+	server.ServeHTTP(w, r)
 }
@@ -0,0 +1,56 @@
+package sources
+
+import "time"
+
+// DefaultHistory is how many earlier versions of an in-memory file are kept
+const DefaultHistory = 10
+
+// buffer is the markdown of an in-memory file, which edits are applied to,
+// along with the renderer it was sent for and how many earlier versions of it
+// are kept
+type buffer struct {
+	// the current text last, after the earlier versions that are kept
+	revisions []revision
+	renderer  string
+	history   int
+}
+
+// revision is a version of the text of a buffer
+type revision struct {
+	version int
+	text    []byte
+	updated time.Time
+}
+
+// current returns the current revision of the buffer
+func (b *buffer) current() revision {
+	if len(b.revisions) == 0 {
+		return revision{}
+	}
+	return b.revisions[len(b.revisions)-1]
+}
+
+// revision returns a kept version of the text
+func (b *buffer) revision(version int) (revision, bool) {
+	for _, rev := range b.revisions {
+		if rev.version == version {
+			return rev, true
+		}
+	}
+	return revision{}, false
+}
+
+// push makes text the current version, keeping up to the buffer's history of
+// earlier ones, and returns the new version
+func (b *buffer) push(text []byte) int {
+	rev := revision{version: b.current().version + 1, text: text, updated: time.Now()}
+	b.revisions = append(b.revisions, rev)
+	history := b.history
+	if history < 0 {
+		history = 0
+	}
+	if extra := len(b.revisions) - history - 1; extra > 0 {
+		b.revisions = append([]revision(nil), b.revisions[extra:]...)
+	}
+	return rev.version
+}
@@ -1,12 +1,64 @@
 package sources
 
+import (
+	"github.com/davinche/godown/dispatch"
+	"github.com/davinche/godown/render"
+	"github.com/davinche/godown/server"
+)
+
 // Source is the interface for a markdown file provider
 type Source interface {
 	GetID(string) (string, error)
+	AssetRoot(string) (string, error)
+	Documents() []server.DocumentInfo
 	Wait()
 }
 
-// RenderFormat is the struct that holds the rendered markdown
+// changed lets the dashboard know that a document changed
+func changed(dispatcher *dispatch.Dispatcher, id string) {
+	dispatcher.Dispatch(&dispatch.DocChangeEvent{ID: id})
+}
+
+// Types of messages sent to websocket clients
+const (
+	RenderFull   = "full"
+	RenderPatch  = "patch"
+	RenderCursor = "cursor"
+)
+
+// Patch operations applied in order to the client's blocks
+const (
+	PatchKeep   = "keep"
+	PatchDelete = "delete"
+	PatchInsert = "insert"
+)
+
+// RenderFormat is the struct that holds the rendered markdown. A full render
+// carries every top-level block of the document; a patch carries the
+// operations that turn version Base into Version. Lines holds the source lines
+// of every block of the new version.
 type RenderFormat struct {
-	Render string `json:"render"`
+	Type    string                 `json:"type"`
+	Version int                    `json:"version"`
+	Base    int                    `json:"base"`
+	Title   string                 `json:"title,omitempty"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Outline []render.Heading       `json:"outline,omitempty"`
+	Lines   []render.LineRange     `json:"lines,omitempty"`
+	Blocks  []string               `json:"blocks,omitempty"`
+	Ops     []PatchOp              `json:"ops,omitempty"`
+}
+
+// CursorFormat asks clients to scroll to the block rendered from a line of
+// the source, counting from 1
+type CursorFormat struct {
+	Type string `json:"type"`
+	Line int    `json:"line"`
+}
+
+// PatchOp keeps or deletes the next Count blocks, or inserts new Blocks
+type PatchOp struct {
+	Op     string   `json:"op"`
+	Count  int      `json:"count,omitempty"`
+	Blocks []string `json:"blocks,omitempty"`
 }
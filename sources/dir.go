@@ -0,0 +1,418 @@
+package sources
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/davinche/godown/dispatch"
+	"github.com/davinche/godown/render"
+	"github.com/davinche/godown/server"
+)
+
+// how often a previewed directory is scanned for added or removed files
+const scanInterval = 2 * time.Second
+
+// Dir is used to preview every markdown file below a directory. The files
+// themselves are watched by the File source; Dir keeps the set of files in
+// sync with the directory and renders a browsable index page.
+type Dir struct {
+	dispatcher *dispatch.Dispatcher
+	renderers  *render.Registry
+	docs       map[string]*document
+	dirs       map[string]*DirWatcher
+	loop       *loop
+	done       chan struct{}
+
+	// the directory each file below a previewed directory belongs to, and
+	// the browsers looking at the files of each directory
+	owners  map[string]string
+	viewers map[string]map[*websocket.Conn]struct{}
+
+	// how long a directory is watched once neither its index nor any of its
+	// files has clients
+	expireAfter time.Duration
+}
+
+// NewDir is the constructor for a new directory tracker
+func NewDir(d *dispatch.Dispatcher, renderers *render.Registry) *Dir {
+	return &Dir{
+		dispatcher: d,
+		renderers:  renderers,
+		docs:       make(map[string]*document),
+		dirs:       make(map[string]*DirWatcher),
+		loop:       newLoop(),
+		done:       make(chan struct{}),
+		owners:     make(map[string]string),
+		viewers:    make(map[string]map[*websocket.Conn]struct{}),
+	}
+}
+
+// EventTypes are the dispatched events the Dir source handles
+func (d *Dir) EventTypes() []dispatch.EventType {
+	return []dispatch.EventType{
+		dispatch.FileAdd,
+		dispatch.FileDelete,
+		dispatch.DirChange,
+		dispatch.AddClient,
+		dispatch.DelClient,
+		dispatch.ResyncClient,
+		dispatch.Shutdown,
+	}
+}
+
+// ServeEvent handles dispatched messages on the source's loop
+func (d *Dir) ServeEvent(e dispatch.Event) (err error) {
+	if stopErr := d.loop.do(func() { err = d.serveEvent(e) }); stopErr != nil {
+		return stopErr
+	}
+	return err
+}
+
+func (d *Dir) serveEvent(e dispatch.Event) error {
+	switch e := e.(type) {
+	case *dispatch.FileAddEvent:
+		if e.Root != "" {
+			return d.addFile(e)
+		}
+		return d.addDir(e)
+	case *dispatch.FileDeleteEvent:
+		return d.delDir(e.Path)
+	case *dispatch.DirChangeEvent:
+		return d.broadcast(e)
+	case *dispatch.AddClientEvent:
+		return d.addClient(e.Client)
+	case *dispatch.DelClientEvent:
+		return d.delClient(e.Client)
+	case *dispatch.ResyncClientEvent:
+		return d.resyncClient(e.Client)
+	case *dispatch.ShutdownEvent:
+		return d.close()
+	}
+	return nil
+}
+
+// Wait for termination
+func (d *Dir) Wait() {
+	<-d.done
+}
+
+// GetID returns the unique id for a previewed directory
+func (d *Dir) GetID(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("dir error: cannot get absolute path: err=%q", err)
+	}
+
+	id := getID(absPath)
+	var ok bool
+	d.loop.do(func() { _, ok = d.dirs[id] })
+	if ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("dir warning: cannot find directory: path=%q; id=%q", path, id)
+}
+
+// AssetRoot returns the previewed directory itself
+func (d *Dir) AssetRoot(id string) (string, error) {
+	var root string
+	d.loop.do(func() {
+		if dir, ok := d.dirs[id]; ok {
+			root = dir.root
+		}
+	})
+	if root != "" {
+		return root, nil
+	}
+	return "", fmt.Errorf("dir warning: cannot find directory: id=%q", id)
+}
+
+// ExpireAfter stops watching directories, and the files below them, once they
+// have gone without clients for a while; 0 watches them until they are
+// stopped. Set it before calling Serve.
+func (d *Dir) ExpireAfter(after time.Duration) {
+	d.loop.do(func() { d.expireAfter = after })
+}
+
+// Documents describes the index page of every previewed directory
+func (d *Dir) Documents() []server.DocumentInfo {
+	docs := make([]server.DocumentInfo, 0)
+	d.loop.do(func() {
+		for id, doc := range d.docs {
+			docs = append(docs, doc.info(id))
+		}
+	})
+	return docs
+}
+
+// starts previewing a directory
+func (d *Dir) addDir(e *dispatch.FileAddEvent) error {
+	absPath, err := filepath.Abs(e.Path)
+	if err != nil {
+		log.Printf("dir error: cannot get absolute path: err=%q\n", err)
+		return nil
+	}
+
+	// regular files are handled by the File source
+	if stat, err := os.Stat(absPath); err != nil || !stat.IsDir() {
+		return nil
+	}
+
+	id := getID(absPath)
+	if _, ok := d.dirs[id]; !ok {
+		log.Printf("dir status: started watching directory: id=%q; path=%q\n", id, absPath)
+		watcher := NewDirWatcher(d.dispatcher, d.renderers, absPath, e.Renderer)
+		d.dirs[id] = watcher
+
+		log.Printf("dir status: now accepting clients: id=%q\n", id)
+		doc := newDocument(server.SourceDirectory, absPath)
+		doc.update(&render.Result{HTML: watcher.Start()})
+		d.docs[id] = doc
+		changed(d.dispatcher, id)
+	}
+	return nil
+}
+
+// remembers which directory a file added by a directory watcher belongs to
+func (d *Dir) addFile(e *dispatch.FileAddEvent) error {
+	dirID := getID(e.Root)
+	if _, ok := d.dirs[dirID]; ok {
+		d.owners[getID(e.Path)] = dirID
+	}
+	return nil
+}
+
+// stops previewing a directory and every file below it
+func (d *Dir) delDir(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		log.Printf("dir error: cannot get absolute path: err=%q\n", err)
+		return nil
+	}
+
+	id := getID(absPath)
+	delete(d.owners, id)
+	delete(d.viewers, id)
+	if doc, ok := d.docs[id]; ok {
+		log.Printf("dir status: untracking directory: id=%q\n", id)
+		doc.close()
+		delete(d.docs, id)
+		changed(d.dispatcher, id)
+	}
+
+	if watcher, ok := d.dirs[id]; ok {
+		watcher.Close()
+		delete(d.dirs, id)
+	}
+	return nil
+}
+
+func (d *Dir) addClient(request *dispatch.Client) error {
+	if doc, ok := d.docs[request.ID]; ok {
+		log.Printf("dir status: adding client to the watch list: id=%q\n", request.ID)
+		doc.addClient(request.WS)
+		changed(d.dispatcher, request.ID)
+		return nil
+	}
+
+	// a browser looking at one of the files keeps the directory watched
+	if dirID, ok := d.owners[request.ID]; ok {
+		if doc, ok := d.docs[dirID]; ok {
+			if d.viewers[dirID] == nil {
+				d.viewers[dirID] = make(map[*websocket.Conn]struct{})
+			}
+			d.viewers[dirID][request.WS] = struct{}{}
+			doc.stopIdle()
+		}
+	}
+	return nil
+}
+
+func (d *Dir) delClient(request *dispatch.Client) error {
+	id, removed := request.ID, false
+	if doc, ok := d.docs[id]; ok {
+		if removed = doc.removeClient(request.WS); removed {
+			log.Printf("dir status: removing client from the watch list: id=%q\n", id)
+			changed(d.dispatcher, id)
+		}
+	} else if dirID, ok := d.owners[id]; ok {
+		id = dirID
+		if _, removed = d.viewers[id][request.WS]; removed {
+			delete(d.viewers[id], request.WS)
+		}
+	}
+
+	// nobody is looking at the directory or any of its files anymore
+	doc, ok := d.docs[id]
+	if ok && len(doc.clients) == 0 && len(d.viewers[id]) == 0 && (removed || doc.idle == nil) {
+		doc.expireWhenIdle(d.loop, d.expireAfter, func() {
+			if len(d.viewers[id]) > 0 {
+				return
+			}
+			log.Printf("dir status: untracking idle directory: id=%q; after=%v\n", id, d.expireAfter)
+			d.delDir(doc.name)
+		})
+	}
+	return nil
+}
+
+func (d *Dir) resyncClient(request *dispatch.Client) error {
+	if doc, ok := d.docs[request.ID]; ok {
+		doc.resync(request.WS)
+	}
+	return nil
+}
+
+func (d *Dir) broadcast(change *dispatch.DirChangeEvent) error {
+	id := getID(change.Path)
+	if doc, ok := d.docs[id]; ok {
+		doc.update(&render.Result{HTML: change.HTML})
+		changed(d.dispatcher, id)
+	}
+	return nil
+}
+
+func (d *Dir) close() error {
+	for _, doc := range d.docs {
+		doc.close()
+	}
+
+	for _, watcher := range d.dirs {
+		watcher.Close()
+	}
+	d.loop.stop()
+	close(d.done)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Directory Watcher ----------------------------------------------------------
+// ----------------------------------------------------------------------------
+
+// NewDirWatcher is the constructor for a new directory watcher
+func NewDirWatcher(d *dispatch.Dispatcher, renderers *render.Registry, root, renderer string) *DirWatcher {
+	return &DirWatcher{
+		dispatcher: d,
+		renderers:  renderers,
+		root:       root,
+		renderer:   renderer,
+		files:      make(map[string]struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// DirWatcher keeps the markdown files below a directory previewed
+type DirWatcher struct {
+	dispatcher *dispatch.Dispatcher
+	renderers  *render.Registry
+	root       string
+	renderer   string
+	files      map[string]struct{}
+	index      string
+	done       chan struct{}
+}
+
+// Start previews the files currently in the directory, begins scanning for
+// changes and returns the rendered index page
+func (w *DirWatcher) Start() string {
+	w.scan()
+	index := w.index
+	go func() {
+		for {
+			select {
+			case <-w.done:
+				// stop previewing the files below the directory
+				for file := range w.files {
+					w.dispatcher.Dispatch(&dispatch.FileDeleteEvent{Path: file})
+				}
+				return
+			case <-time.After(scanInterval):
+				if w.scan() {
+					w.dispatcher.Dispatch(&dispatch.DirChangeEvent{
+						Path: w.root,
+						HTML: w.index,
+					})
+				}
+			}
+		}
+	}()
+	return index
+}
+
+// Close stops previewing the directory and the files below it
+func (w *DirWatcher) Close() {
+	close(w.done)
+}
+
+// scan walks the directory, adding and removing files as needed, and reports
+// whether the set of files has changed
+func (w *DirWatcher) scan() bool {
+	found := make(map[string]struct{})
+	filepath.Walk(w.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		// skip hidden directories such as .git
+		if info.IsDir() && path != w.root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && w.renderers.Handles(path) {
+			found[path] = struct{}{}
+		}
+		return nil
+	})
+
+	changed := false
+	for path := range found {
+		if _, ok := w.files[path]; !ok {
+			changed = true
+			w.dispatcher.Dispatch(&dispatch.FileAddEvent{
+				Path:     path,
+				Renderer: w.renderer,
+				Root:     w.root,
+			})
+		}
+	}
+	for path := range w.files {
+		if _, ok := found[path]; !ok {
+			changed = true
+			w.dispatcher.Dispatch(&dispatch.FileDeleteEvent{Path: path})
+		}
+	}
+	w.files = found
+
+	if changed || w.index == "" {
+		w.index = w.renderIndex()
+	}
+	return changed
+}
+
+// renderIndex renders a page linking to every file, grouped by directory
+func (w *DirWatcher) renderIndex() string {
+	paths := make([]string, 0, len(w.files))
+	for path := range w.files {
+		if rel, err := filepath.Rel(w.root, path); err == nil {
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+	}
+	index := render.Index(filepath.Base(w.root), paths, func(rel string) string {
+		return "/?id=" + getID(filepath.Join(w.root, filepath.FromSlash(rel)))
+	})
+
+	renderer, err := w.renderers.Select("", "index.md")
+	if err != nil {
+		log.Printf("dir error: cannot render index: path=%q; err=%q\n", w.root, err)
+		return ""
+	}
+	result, err := renderer.Render(index)
+	if err != nil {
+		log.Printf("dir error: cannot render index: path=%q; err=%q\n", w.root, err)
+		return ""
+	}
+	return result.HTML
+}
@@ -0,0 +1,245 @@
+package sources
+
+import (
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/net/websocket"
+
+	"github.com/davinche/godown/render"
+	"github.com/davinche/godown/server"
+)
+
+// how long a client gets to receive a message before it is dropped, so that
+// a stalled browser cannot hold up the source's loop
+const sendTimeout = 5 * time.Second
+
+// document tracks the clients previewing a rendered markdown file along with
+// the last render they were sent, so that updates only contain the blocks that
+// changed
+type document struct {
+	clients map[*websocket.Conn]struct{}
+	version int
+	blocks  []string
+
+	// what the dashboard shows about the document
+	source  string
+	name    string
+	title   string
+	meta    map[string]interface{}
+	outline []render.Heading
+	lines   []render.LineRange
+	updated time.Time
+
+	// the source line editors last placed their cursor on
+	line int
+
+	// expires the document once it has gone without clients for a while
+	idle *time.Timer
+}
+
+func newDocument(source, name string) *document {
+	return &document{
+		clients: make(map[*websocket.Conn]struct{}),
+		blocks:  make([]string, 0),
+		source:  source,
+		name:    name,
+	}
+}
+
+// info describes the document for the dashboard
+func (d *document) info(id string) server.DocumentInfo {
+	return server.DocumentInfo{
+		ID:      id,
+		Source:  d.source,
+		Name:    d.name,
+		Title:   d.title,
+		Meta:    d.meta,
+		Updated: d.updated,
+		Clients: len(d.clients),
+	}
+}
+
+// addClient starts sending updates to a client, beginning with the full render
+func (d *document) addClient(ws *websocket.Conn) {
+	d.stopIdle()
+	d.clients[ws] = struct{}{}
+	d.resync(ws)
+}
+
+// removeClient stops sending updates to a client that disconnected and
+// reports whether it was one of ours
+func (d *document) removeClient(ws *websocket.Conn) bool {
+	if _, ok := d.clients[ws]; !ok {
+		return false
+	}
+	delete(d.clients, ws)
+	return true
+}
+
+// expireWhenIdle calls expire on a source's loop once the document has gone
+// without clients for a while, unless it is called again first. Nothing
+// expires after a duration of 0.
+func (d *document) expireWhenIdle(l *loop, after time.Duration, expire func()) {
+	d.stopIdle()
+	if after <= 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(after, func() {
+		l.do(func() {
+			if d.idle != timer {
+				return
+			}
+			d.idle = nil
+			if len(d.clients) == 0 {
+				expire()
+			}
+		})
+	})
+	d.idle = timer
+}
+
+func (d *document) stopIdle() {
+	if d.idle != nil {
+		d.idle.Stop()
+		d.idle = nil
+	}
+}
+
+// resync sends the full render to a client whose copy is out of date, along
+// with where the editor's cursor is
+func (d *document) resync(ws *websocket.Conn) {
+	if _, ok := d.clients[ws]; !ok {
+		return
+	}
+	if err := send(ws, d.full()); err != nil {
+		delete(d.clients, ws)
+		return
+	}
+	if d.line > 0 {
+		if err := send(ws, CursorFormat{Type: RenderCursor, Line: d.line}); err != nil {
+			delete(d.clients, ws)
+		}
+	}
+}
+
+// update stores a new render and sends the changed blocks to every client.
+// Renders without a title are named after their first heading, and renders
+// without an outline are outlined by their headings.
+func (d *document) update(result *render.Result) {
+	blocks := render.Blocks(result.HTML)
+	ops := diffBlocks(d.blocks, blocks)
+	d.blocks = blocks
+	d.version++
+	d.title = result.Title
+	if d.title == "" {
+		d.title = render.Title([]byte(result.HTML))
+	}
+	d.meta = result.Meta
+	d.outline = result.Outline
+	if d.outline == nil {
+		d.outline = render.Outline(result.HTML)
+	}
+	d.lines = result.Lines
+	d.updated = time.Now()
+	if len(d.clients) == 0 {
+		return
+	}
+
+	msg := RenderFormat{
+		Type:    RenderPatch,
+		Version: d.version,
+		Base:    d.version - 1,
+		Title:   d.title,
+		Meta:    d.meta,
+		Outline: d.outline,
+		Lines:   d.lines,
+		Ops:     ops,
+	}
+	d.broadcast(msg)
+}
+
+// cursor scrolls every client to the block rendered from a line of the source
+func (d *document) cursor(line int) {
+	d.line = line
+	d.broadcast(CursorFormat{Type: RenderCursor, Line: line})
+}
+
+// broadcast sends a message to every client, dropping the ones that fail
+func (d *document) broadcast(msg interface{}) {
+	for client := range d.clients {
+		if err := send(client, msg); err != nil {
+			log.Printf("document warning: dropping client: err=%q\n", err)
+			delete(d.clients, client)
+		}
+	}
+}
+
+// full returns a message containing every block of the current render
+func (d *document) full() RenderFormat {
+	return RenderFormat{
+		Type:    RenderFull,
+		Version: d.version,
+		Title:   d.title,
+		Meta:    d.meta,
+		Outline: d.outline,
+		Lines:   d.lines,
+		Blocks:  d.blocks,
+	}
+}
+
+// close disconnects every client
+func (d *document) close() {
+	d.stopIdle()
+	for client := range d.clients {
+		client.Close()
+	}
+	d.clients = make(map[*websocket.Conn]struct{})
+}
+
+// send writes a message to a client, giving up after the send timeout
+func send(ws *websocket.Conn, msg interface{}) error {
+	ws.SetWriteDeadline(time.Now().Add(sendTimeout))
+	return websocket.JSON.Send(ws, msg)
+}
+
+// diffBlocks returns the operations that turn the old blocks into the new ones
+func diffBlocks(old, new []string) []PatchOp {
+	// map every distinct block onto a rune so the blocks can be diffed as text
+	runes := make(map[string]rune)
+	encode := func(blocks []string) []rune {
+		encoded := make([]rune, len(blocks))
+		for i, block := range blocks {
+			r, ok := runes[block]
+			if !ok {
+				r = rune(len(runes) + 1)
+				runes[block] = r
+			}
+			encoded[i] = r
+		}
+		return encoded
+	}
+	oldRunes, newRunes := encode(old), encode(new)
+
+	dmp := diffmatchpatch.New()
+	ops := make([]PatchOp, 0)
+	offset := 0
+	for _, diff := range dmp.DiffMainRunes(oldRunes, newRunes, false) {
+		count := utf8.RuneCountInString(diff.Text)
+		switch diff.Type {
+		case diffmatchpatch.DiffEqual:
+			ops = append(ops, PatchOp{Op: PatchKeep, Count: count})
+		case diffmatchpatch.DiffDelete:
+			ops = append(ops, PatchOp{Op: PatchDelete, Count: count})
+		case diffmatchpatch.DiffInsert:
+			ops = append(ops, PatchOp{Op: PatchInsert, Blocks: new[offset : offset+count]})
+		}
+		if diff.Type != diffmatchpatch.DiffDelete {
+			offset += count
+		}
+	}
+	return ops
+}
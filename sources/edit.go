@@ -0,0 +1,77 @@
+package sources
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/davinche/godown/dispatch"
+)
+
+// applyEdits returns the text with every edit applied, each to the text the
+// previous ones left
+func applyEdits(text []byte, edits []dispatch.Edit) ([]byte, error) {
+	for i, edit := range edits {
+		start, end, err := editRange(text, edit)
+		if err != nil {
+			return nil, fmt.Errorf("memory error: invalid edit: edit=%d; err=%q", i, err)
+		}
+		next := make([]byte, 0, len(text)-(end-start)+len(edit.Text))
+		next = append(next, text[:start]...)
+		next = append(next, edit.Text...)
+		next = append(next, text[end:]...)
+		text = next
+	}
+	return text, nil
+}
+
+// editRange returns the byte offsets of the range an edit replaces
+func editRange(text []byte, edit dispatch.Edit) (int, int, error) {
+	if edit.Start == nil {
+		if edit.End != nil {
+			return 0, 0, fmt.Errorf("end without a start")
+		}
+		if edit.Offset < 0 || edit.Length < 0 || edit.Offset+edit.Length > len(text) {
+			return 0, 0, fmt.Errorf("range %d+%d is outside of the text of %d bytes", edit.Offset, edit.Length, len(text))
+		}
+		return edit.Offset, edit.Offset + edit.Length, nil
+	}
+
+	start, err := offset(text, *edit.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start
+	if edit.End != nil {
+		if end, err = offset(text, *edit.End); err != nil {
+			return 0, 0, err
+		}
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("range ends before it starts")
+	}
+	return start, end, nil
+}
+
+// offset returns the byte offset of a position, which may be at the end of its
+// line but not past it
+func offset(text []byte, pos dispatch.Position) (int, error) {
+	if pos.Line < 0 || pos.Column < 0 {
+		return 0, fmt.Errorf("negative position %d:%d", pos.Line, pos.Column)
+	}
+	lineStart := 0
+	for line := 0; line < pos.Line; line++ {
+		i := bytes.IndexByte(text[lineStart:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is past the end of the text", pos.Line)
+		}
+		lineStart += i + 1
+	}
+	lineEnd := len(text)
+	if i := bytes.IndexByte(text[lineStart:], '\n'); i >= 0 {
+		lineEnd = lineStart + i
+	}
+	if lineStart+pos.Column > lineEnd {
+		return 0, fmt.Errorf("column %d is past the end of line %d", pos.Column, pos.Line)
+	}
+	return lineStart + pos.Column, nil
+}
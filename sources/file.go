@@ -5,49 +5,82 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
-	"golang.org/x/net/websocket"
-
 	"github.com/davinche/godown/dispatch"
+	"github.com/davinche/godown/internal/paths"
+	"github.com/davinche/godown/render"
 	"github.com/davinche/godown/server"
-	md "github.com/shurcooL/github_flavored_markdown"
 )
 
 // File is used to track watched files
 type File struct {
 	dispatcher *dispatch.Dispatcher
-	watching   map[string]map[*websocket.Conn]struct{}
+	renderers  *render.Registry
+	docs       map[string]*document
 	watchers   map[string]*Watcher
+	loop       *loop
 	done       chan struct{}
+
+	// how long a file is watched after its last client disconnects
+	expireAfter time.Duration
 }
 
 // NewFile is the constructor for a new Files tracker
-func NewFile(d *dispatch.Dispatcher) *File {
+func NewFile(d *dispatch.Dispatcher, renderers *render.Registry) *File {
 	return &File{
 		dispatcher: d,
-		watching:   make(map[string]map[*websocket.Conn]struct{}),
+		renderers:  renderers,
+		docs:       make(map[string]*document),
 		watchers:   make(map[string]*Watcher),
+		loop:       newLoop(),
 		done:       make(chan struct{}),
 	}
 }
 
-// ServeRequest handles dispatched messages
-func (f *File) ServeRequest(r *dispatch.Request) error {
-	switch r.Type {
-	case "FILE_ADD":
-		return f.addFile(r.Value.(string))
-	case "FILE_DELETE":
-		return f.delFile(r.Value.(string))
-	case "FILE_CHANGE":
-		change := r.Value.(*fileChange)
-		return f.broadcast(change)
-	case "ADD_WSCLIENT":
-		clientRequest := r.Value.(*server.WebsocketRequest)
-		return f.addClient(clientRequest)
-	case "SHUTDOWN":
+// EventTypes are the dispatched events the File source handles
+func (f *File) EventTypes() []dispatch.EventType {
+	return []dispatch.EventType{
+		dispatch.FileAdd,
+		dispatch.FileDelete,
+		dispatch.FileChange,
+		dispatch.AddClient,
+		dispatch.DelClient,
+		dispatch.ResyncClient,
+		dispatch.Cursor,
+		dispatch.Shutdown,
+	}
+}
+
+// ServeEvent handles dispatched messages on the source's loop
+func (f *File) ServeEvent(e dispatch.Event) (err error) {
+	if stopErr := f.loop.do(func() { err = f.serveEvent(e) }); stopErr != nil {
+		return stopErr
+	}
+	return err
+}
+
+func (f *File) serveEvent(e dispatch.Event) error {
+	switch e := e.(type) {
+	case *dispatch.FileAddEvent:
+		return f.addFile(e)
+	case *dispatch.FileDeleteEvent:
+		return f.delFile(e.Path)
+	case *dispatch.FileChangeEvent:
+		return f.broadcast(e)
+	case *dispatch.AddClientEvent:
+		return f.addClient(e.Client)
+	case *dispatch.DelClientEvent:
+		return f.delClient(e.Client)
+	case *dispatch.ResyncClientEvent:
+		return f.resyncClient(e.Client)
+	case *dispatch.CursorEvent:
+		return f.cursor(e)
+	case *dispatch.ShutdownEvent:
 		return f.close()
 	}
 	return nil
@@ -66,53 +99,143 @@ func (f *File) GetID(path string) (string, error) {
 	}
 
 	id := getID(absPath)
-	if _, ok := f.watchers[id]; ok {
+	var ok bool
+	f.loop.do(func() { _, ok = f.watchers[id] })
+	if ok {
 		return id, nil
 	}
 	return "", fmt.Errorf("file warning: cannot find file: path=%q; id=%q", path, id)
 }
 
+// AssetRoot returns the directory of a watched file so that its relative
+// images and links can be served
+func (f *File) AssetRoot(id string) (string, error) {
+	var root string
+	f.loop.do(func() {
+		if watcher, ok := f.watchers[id]; ok {
+			root = watcher.assetRoot()
+		}
+	})
+	if root != "" {
+		return root, nil
+	}
+	return "", fmt.Errorf("file warning: cannot find file: id=%q", id)
+}
+
+// ExpireAfter stops watching files once they have gone without clients for a
+// while; 0 watches them until they are stopped. Set it before calling Serve.
+func (f *File) ExpireAfter(after time.Duration) {
+	f.loop.do(func() { f.expireAfter = after })
+}
+
+// Documents describes every watched file
+func (f *File) Documents() []server.DocumentInfo {
+	docs := make([]server.DocumentInfo, 0)
+	f.loop.do(func() {
+		for id, doc := range f.docs {
+			docs = append(docs, doc.info(id))
+		}
+	})
+	return docs
+}
+
 // adds a file to be watched
-func (f *File) addFile(path string) error {
-	absPath, err := filepath.Abs(path)
+func (f *File) addFile(e *dispatch.FileAddEvent) error {
+	// an explicitly requested renderer takes precedence over the file extension
+	renderer, err := f.renderers.Select(e.Renderer, e.Path)
+	if err != nil {
+		return err
+	}
+
+	absPath, err := filepath.Abs(e.Path)
 	if err != nil {
 		log.Printf("file error: cannot get absolute path: err=%q\n", err)
 		return nil
 	}
-	id := getID(absPath)
-	if _, ok := f.watching[id]; !ok {
-		log.Printf("file status: now accepting clients: id=%q\n", id)
-		f.watching[id] = make(map[*websocket.Conn]struct{})
+
+	// directories are handled by the Dir source
+	if stat, err := os.Stat(absPath); err == nil && stat.IsDir() {
+		return nil
 	}
 
+	id := getID(absPath)
 	if _, ok := f.watchers[id]; !ok {
+		watcher := NewWatcher(f.dispatcher, absPath, renderer)
+
+		// files that belong to a previewed directory can link to each other
+		if e.Root != "" {
+			watcher.root = e.Root
+			watcher.linkable = f.renderers.Handles
+		}
+		rendered, err := watcher.Start()
+		if err != nil {
+			return fmt.Errorf("file error: cannot watch file: path=%q; err=%q", absPath, err)
+		}
 		log.Printf("file status: started watching file: id=%q\n", id)
-		watcher := NewWatcher(f.dispatcher, absPath)
 		f.watchers[id] = watcher
-		watcher.Start()
+
+		log.Printf("file status: now accepting clients: id=%q\n", id)
+		doc := newDocument(server.SourceFile, absPath)
+		doc.update(rendered)
+		f.docs[id] = doc
+		changed(f.dispatcher, id)
 	}
 	return nil
 }
 
-func (f *File) addClient(request *server.WebsocketRequest) error {
+func (f *File) addClient(request *dispatch.Client) error {
 	// see if we're already watching the file
-	watching, ok := f.watching[request.ID]
+	doc, ok := f.docs[request.ID]
 	if !ok {
 		log.Printf("watching error: currently not watching file: id=%q\n", request.ID)
 		return nil
 	}
 
-	// Add the client to the set of file listeners
-	_, ok = watching[request.WS]
+	// Add the client to the set of file listeners and send it the latest render
+	log.Printf("watching status: adding client to the watch list: id=%q\n", request.ID)
+	doc.addClient(request.WS)
+	changed(f.dispatcher, request.ID)
+	return nil
+}
+
+func (f *File) delClient(request *dispatch.Client) error {
+	doc, ok := f.docs[request.ID]
 	if !ok {
-		log.Printf("watching status: adding client to the watch list: id=%q\n", request.ID)
-		watching[request.WS] = struct{}{}
+		return nil
+	}
+	removed := doc.removeClient(request.WS)
+	if removed {
+		log.Printf("watching status: removing client from the watch list: id=%q\n", request.ID)
+		changed(f.dispatcher, request.ID)
+	}
+
+	// files below a previewed directory are watched for as long as the
+	// directory is; the Dir source expires them along with it
+	if watcher, ok := f.watchers[request.ID]; ok && watcher.root != "" {
+		return nil
 	}
 
-	// Ask our watcher to update the client
-	if watcher, ok := f.watchers[request.ID]; ok {
-		log.Printf("watching status: updating client with new data: id=%q\n", request.ID)
-		watcher.Update(request.WS)
+	// nobody is looking at the file anymore
+	if len(doc.clients) == 0 && (removed || doc.idle == nil) {
+		doc.expireWhenIdle(f.loop, f.expireAfter, func() {
+			log.Printf("file status: untracking idle file: id=%q; after=%v\n", request.ID, f.expireAfter)
+			f.delFile(doc.name)
+		})
+	}
+	return nil
+}
+
+func (f *File) resyncClient(request *dispatch.Client) error {
+	if doc, ok := f.docs[request.ID]; ok {
+		log.Printf("watching status: resyncing client: id=%q\n", request.ID)
+		doc.resync(request.WS)
+	}
+	return nil
+}
+
+func (f *File) cursor(e *dispatch.CursorEvent) error {
+	if doc, ok := f.docs[e.ID]; ok {
+		doc.cursor(e.Line)
 	}
 	return nil
 }
@@ -127,12 +250,11 @@ func (f *File) delFile(path string) error {
 
 	id := getID(absPath)
 	// close the currently opened websockets
-	if watching, ok := f.watching[id]; ok {
+	if doc, ok := f.docs[id]; ok {
 		log.Printf("file status: untracking file: id=%q\n", id)
-		for client := range watching {
-			client.Close()
-		}
-		delete(f.watching, id)
+		doc.close()
+		delete(f.docs, id)
+		changed(f.dispatcher, id)
 	}
 
 	// stop watching the file
@@ -143,31 +265,24 @@ func (f *File) delFile(path string) error {
 	return nil
 }
 
-func (f *File) broadcast(change *fileChange) error {
+func (f *File) broadcast(change *dispatch.FileChangeEvent) error {
 	id := getID(change.Path)
-	if watching, ok := f.watching[id]; ok {
-		for client := range watching {
-			err := websocket.JSON.Send(client, RenderFormat{
-				Render: change.Value,
-			})
-			if err != nil {
-				delete(watching, client)
-			}
-		}
+	if doc, ok := f.docs[id]; ok {
+		doc.update(change.Result)
+		changed(f.dispatcher, id)
 	}
 	return nil
 }
 
 func (f *File) close() error {
-	for _, watcher := range f.watching {
-		for client := range watcher {
-			client.Close()
-		}
+	for _, doc := range f.docs {
+		doc.close()
 	}
 
 	for _, watcher := range f.watchers {
 		watcher.Close()
 	}
+	f.loop.stop()
 	close(f.done)
 	return nil
 }
@@ -176,11 +291,18 @@ func (f *File) close() error {
 // Watcher --------------------------------------------------------------------
 // ----------------------------------------------------------------------------
 
+// how long the watcher waits for a burst of file events to settle
+const debounceInterval = 50 * time.Millisecond
+
+// how often the fallback watcher polls the file for changes
+const pollInterval = time.Second
+
 // NewWatcher is the constructor for a new file watcher
-func NewWatcher(d *dispatch.Dispatcher, filePath string) *Watcher {
+func NewWatcher(d *dispatch.Dispatcher, filePath string, renderer render.Renderer) *Watcher {
 	return &Watcher{
 		dispatcher: d,
 		filePath:   filePath,
+		renderer:   renderer,
 		done:       make(chan struct{}),
 	}
 }
@@ -189,62 +311,174 @@ func NewWatcher(d *dispatch.Dispatcher, filePath string) *Watcher {
 type Watcher struct {
 	dispatcher *dispatch.Dispatcher
 	filePath   string
+	renderer   render.Renderer
 	done       chan struct{}
+
+	// the directory the file was previewed as part of, if any, and a check for
+	// which of the files below it are previewed as well
+	root     string
+	linkable func(path string) bool
+
+	// state of the file the last time it was rendered
+	digest  [sha1.Size]byte
+	missing bool
 }
 
 // Start begins watching a file
-func (w *Watcher) Start() (string, error) {
+func (w *Watcher) Start() (*render.Result, error) {
 	log.Printf("watcher status: starting watcher: file=%q", w.filePath)
 	stat, err := os.Stat(w.filePath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	data, err := ioutil.ReadFile(w.filePath)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	w.digest = sha1.Sum(data)
+	result, err := w.renderData(data)
+	if err != nil {
+		return nil, err
 	}
 
-	go func() {
-		for {
-			select {
-			case <-w.done:
-				return
-			case <-time.After(time.Second):
-				newStat, err := os.Stat(w.filePath)
-				if err != nil {
-					continue
-				}
-				if newStat.Size() != stat.Size() || newStat.ModTime() != stat.ModTime() {
-					log.Printf("watcher status: change detected: file=%q", w.filePath)
-					data, err := ioutil.ReadFile(w.filePath)
-					if err != nil {
-						continue
-					}
-					w.dispatcher.Dispatch("FILE_CHANGE", &fileChange{
-						Path:  w.filePath,
-						Value: string(md.Markdown(data)),
-					})
-					stat = newStat
+	events, err := notify(w.filePath, w.done)
+	if err != nil {
+		log.Printf("watcher warning: falling back to polling: file=%q; err=%q", w.filePath, err)
+		go w.poll(stat)
+	} else {
+		go w.wait(events)
+	}
+	return result, nil
+}
+
+// wait debounces the file system events and checks the file once they settle
+func (w *Watcher) wait(events <-chan struct{}) {
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-w.done:
+			return
+		case _, ok := <-events:
+			if !ok {
+				select {
+				case <-w.done:
+					return
+				default:
 				}
+				// the notifier gave up (ie: the directory was removed)
+				stat, _ := os.Stat(w.filePath)
+				log.Printf("watcher warning: lost file notifications, polling instead: file=%q", w.filePath)
+				w.poll(stat)
+				return
 			}
+			settled = time.After(debounceInterval)
+		case <-settled:
+			settled = nil
+			w.check()
 		}
+	}
+}
 
-	}()
-
-	return string(md.Markdown(data)), nil
+// poll periodically stats the file when event notifications are unavailable
+func (w *Watcher) poll(stat os.FileInfo) {
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(pollInterval):
+			newStat, err := os.Stat(w.filePath)
+			if err != nil {
+				w.check()
+				stat = nil
+				continue
+			}
+			if stat == nil || newStat.Size() != stat.Size() || newStat.ModTime() != stat.ModTime() {
+				w.check()
+				stat = newStat
+			}
+		}
+	}
 }
 
-// Update sends the client the markdown data from our file
-func (w *Watcher) Update(ws *websocket.Conn) {
+// check re-renders the file if its contents changed since the last render
+func (w *Watcher) check() {
 	data, err := ioutil.ReadFile(w.filePath)
 	if err != nil {
+		if os.IsNotExist(err) && !w.missing {
+			log.Printf("watcher status: file removed, waiting for it to come back: file=%q", w.filePath)
+			w.missing = true
+		}
 		return
 	}
-	websocket.JSON.Send(ws, RenderFormat{
-		Render: string(md.Markdown(data)),
+	if w.missing {
+		log.Printf("watcher status: file recreated: file=%q", w.filePath)
+		w.missing = false
+	}
+
+	digest := sha1.Sum(data)
+	if digest == w.digest {
+		return
+	}
+	w.digest = digest
+
+	log.Printf("watcher status: change detected: file=%q", w.filePath)
+	result, err := w.renderData(data)
+	if err != nil {
+		log.Printf("watcher error: could not render file: file=%q; err=%q", w.filePath, err)
+		return
+	}
+	w.dispatcher.Dispatch(&dispatch.FileChangeEvent{
+		Path:   w.filePath,
+		Result: result,
 	})
 }
 
+func (w *Watcher) renderData(data []byte) (*render.Result, error) {
+	result, err := w.renderer.Render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// point relative images and links at the assets next to the file
+	result.HTML = render.RewriteURLs(result.HTML, w.rewriteURL)
+	return result, nil
+}
+
+// assetRoot is the directory that relative assets are served from
+func (w *Watcher) assetRoot() string {
+	if w.root != "" {
+		return w.root
+	}
+	return filepath.Dir(w.filePath)
+}
+
+// rewriteURL turns links to other previewed markdown files into navigation
+// within the preview and serves every other relative url from the asset root
+func (w *Watcher) rewriteURL(attr, u string) string {
+	if !render.IsRelative(u) {
+		return u
+	}
+	dir := filepath.Dir(w.filePath)
+	if attr == "href" && w.linkable != nil {
+		if parsed, err := url.Parse(u); err == nil {
+			target := filepath.Join(dir, filepath.FromSlash(parsed.Path))
+			if w.linkable(target) && paths.IsBelow(w.root, target) {
+				link := "/?id=" + getID(target)
+				if parsed.Fragment != "" {
+					link += "#" + parsed.Fragment
+				}
+				return link
+			}
+		}
+	}
+
+	base := server.AssetsURL(getID(w.filePath))
+	if rel, err := filepath.Rel(w.assetRoot(), dir); err == nil && rel != "." {
+		base += filepath.ToSlash(rel) + "/"
+	}
+	return base + strings.TrimPrefix(u, "./")
+}
+
 // Close signals the watcher to stop watching the file
 func (w *Watcher) Close() {
 	close(w.done)
@@ -254,12 +488,6 @@ func (w *Watcher) Close() {
 // HELPERS --------------------------------------------------------------------
 // ----------------------------------------------------------------------------
 
-// transport struct for reporting file changes
-type fileChange struct {
-	Path  string
-	Value string
-}
-
 // heleper to create a unique id for a file path
 func getID(path string) string {
 	return fmt.Sprintf("%x", sha1.Sum([]byte(path)))
@@ -0,0 +1,55 @@
+package sources
+
+import "errors"
+
+var errStopped = errors.New("source has been shut down")
+
+// loop serializes every access to a source's state onto a single goroutine.
+// Dispatched events, lookups from HTTP handlers and timers all go through
+// the loop, so the maps a source owns never need locking.
+type loop struct {
+	actions chan func()
+	stopped chan struct{}
+}
+
+func newLoop() *loop {
+	l := &loop{
+		actions: make(chan func()),
+		stopped: make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *loop) run() {
+	for {
+		select {
+		case action := <-l.actions:
+			action()
+		case <-l.stopped:
+			return
+		}
+	}
+}
+
+// do runs f on the loop and waits for it to finish
+func (l *loop) do(f func()) error {
+	ran := make(chan struct{})
+	action := func() {
+		defer close(ran)
+		f()
+	}
+	select {
+	case l.actions <- action:
+	case <-l.stopped:
+		return errStopped
+	}
+	<-ran
+	return nil
+}
+
+// stop ends the loop once the current action returns; it must only be
+// called from an action running on the loop
+func (l *loop) stop() {
+	close(l.stopped)
+}
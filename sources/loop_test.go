@@ -0,0 +1,63 @@
+package sources
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLoopSerializes(t *testing.T) {
+	l := newLoop()
+	defer l.do(l.stop)
+
+	// the counter is only safe to share because every access is on the loop
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.do(func() { counter++ })
+			}
+		}()
+	}
+	wg.Wait()
+
+	var got int
+	l.do(func() { got = counter })
+	if got != 5000 {
+		t.Errorf("counter is %d, want 5000", got)
+	}
+}
+
+func TestLoopWaitsForAction(t *testing.T) {
+	l := newLoop()
+	defer l.do(l.stop)
+
+	ran := false
+	if err := l.do(func() { ran = true }); err != nil {
+		t.Fatalf("do failed: %v", err)
+	}
+	if !ran {
+		t.Error("do returned before the action ran")
+	}
+}
+
+func TestLoopStopped(t *testing.T) {
+	l := newLoop()
+	if err := l.do(l.stop); err != nil {
+		t.Fatalf("stopping the loop failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.do(func() { t.Error("action ran after the loop stopped") }); err != errStopped {
+				t.Errorf("got %v, want %v", err, errStopped)
+			}
+		}()
+	}
+	wg.Wait()
+}
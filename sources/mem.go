@@ -4,57 +4,183 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"log"
-	"reflect"
+	"time"
 
 	"github.com/davinche/godown/dispatch"
+	"github.com/davinche/godown/render"
 	"github.com/davinche/godown/server"
-	md "github.com/shurcooL/github_flavored_markdown"
-	"golang.org/x/net/websocket"
 )
 
-// MemRequest is the struct that represents the new in memory markdown file
-type MemRequest struct {
-	ID   string
-	Data string
-}
-
 // Mem is used to track clients to in-memory markdown files
 type Mem struct {
 	dispatcher *dispatch.Dispatcher
-	watching   map[string]map[*websocket.Conn]struct{}
-	memData    map[string]string
+	renderers  *render.Registry
+	docs       map[string]*document
+	buffers    map[string]*buffer
+	history    int
+	loop       *loop
 	done       chan struct{}
+
+	// how long a file is kept after it was last sent while nobody looks at it
+	expireAfter time.Duration
+
+	// the snapshots of the files that changed since they were last stored
+	store    *Store
+	dirty    map[string]struct{}
+	flushing bool
 }
 
 // GetID returns a new unique identifer for a given string
 func (m *Mem) GetID(id string) (string, error) {
 	uid := getID(id)
-	if _, ok := m.memData[uid]; ok {
+	var ok bool
+	m.loop.do(func() { _, ok = m.buffers[uid] })
+	if ok {
 		return uid, nil
 	}
 	return "", fmt.Errorf("memory warning: could not find tracked file: id=%q; uid=%q", id, uid)
 }
 
+// AssetRoot always fails since in-memory files do not live in a directory
+func (m *Mem) AssetRoot(id string) (string, error) {
+	return "", fmt.Errorf("memory warning: in-memory files have no assets: id=%q", id)
+}
+
+// Source returns the markdown of a version of an in-memory file, or of the
+// current version when version is 0, along with the version returned
+func (m *Mem) Source(id string, version int) ([]byte, int, error) {
+	var rev revision
+	var ok bool
+	m.loop.do(func() {
+		if buf, tracked := m.buffers[id]; tracked {
+			if version == 0 {
+				rev, ok = buf.current(), true
+			} else {
+				rev, ok = buf.revision(version)
+			}
+		}
+	})
+	if !ok {
+		return nil, 0, fmt.Errorf("memory warning: could not find version of file: id=%q; version=%d", id, version)
+	}
+	return rev.text, rev.version, nil
+}
+
+// KeepHistory sets how many earlier versions of an in-memory file are kept
+// unless it was sent with a depth of its own. Set it before calling Serve.
+func (m *Mem) KeepHistory(depth int) {
+	m.loop.do(func() { m.history = depth })
+}
+
+// UseStore keeps snapshots of the in-memory files in a store. Set it before
+// calling Serve.
+func (m *Mem) UseStore(store *Store) {
+	m.loop.do(func() { m.store = store })
+}
+
+// Restore previews the in-memory files kept in the store, other than the ones
+// that have been sent since
+func (m *Mem) Restore() {
+	m.loop.do(func() {
+		if m.store == nil {
+			return
+		}
+		snaps, err := m.store.load()
+		if err != nil {
+			log.Printf("memory warning: could not load stored files: err=%q\n", err)
+		}
+		for uniqueID, snap := range snaps {
+			if _, ok := m.buffers[uniqueID]; ok || getID(snap.ID) != uniqueID {
+				continue
+			}
+			if err := m.show(snap.ID, []byte(snap.Text), snap.Renderer); err != nil {
+				log.Printf("memory warning: could not restore file: id=%q; err=%q\n", snap.ID, err)
+				continue
+			}
+			log.Printf("memory status: restored file: id=%q; version=%d\n", snap.ID, snap.Version)
+			m.buffers[uniqueID] = &buffer{
+				revisions: []revision{{version: snap.Version, text: []byte(snap.Text), updated: snap.Updated}},
+				renderer:  snap.Renderer,
+				history:   m.history,
+			}
+			if snap.History != nil {
+				m.buffers[uniqueID].history = *snap.History
+			}
+		}
+	})
+}
+
+// ExpireAfter forgets in-memory files that have gone without being sent or
+// looked at for a while; 0 keeps them until they are stopped. Set it before
+// calling Serve.
+func (m *Mem) ExpireAfter(after time.Duration) {
+	m.loop.do(func() { m.expireAfter = after })
+}
+
+// Documents describes every in-memory file
+func (m *Mem) Documents() []server.DocumentInfo {
+	docs := make([]server.DocumentInfo, 0)
+	m.loop.do(func() {
+		for id, doc := range m.docs {
+			docs = append(docs, doc.info(id))
+		}
+	})
+	return docs
+}
+
 // NewMem is the constructor for the Mem tracker
-func NewMem(d *dispatch.Dispatcher) *Mem {
+func NewMem(d *dispatch.Dispatcher, renderers *render.Registry) *Mem {
 	return &Mem{
 		dispatcher: d,
-		watching:   make(map[string]map[*websocket.Conn]struct{}),
-		memData:    make(map[string]string),
+		renderers:  renderers,
+		docs:       make(map[string]*document),
+		buffers:    make(map[string]*buffer),
+		history:    DefaultHistory,
+		dirty:      make(map[string]struct{}),
+		loop:       newLoop(),
 		done:       make(chan struct{}),
 	}
 }
 
-// ServeRequest handles incoming messages from the dispatcher
-func (m *Mem) ServeRequest(r *dispatch.Request) error {
-	switch r.Type {
-	case "MEM_ADD":
-		return m.addFile(r.Value)
-	case "FILE_DELETE":
-		return m.delFile(r.Value.(string))
-	case "ADD_WSCLIENT":
-		return m.addClient(r.Value.(*server.WebsocketRequest))
-	case "SHUTDOWN":
+// EventTypes are the dispatched events the Mem source handles
+func (m *Mem) EventTypes() []dispatch.EventType {
+	return []dispatch.EventType{
+		dispatch.MemAdd,
+		dispatch.MemEdit,
+		dispatch.FileDelete,
+		dispatch.AddClient,
+		dispatch.DelClient,
+		dispatch.ResyncClient,
+		dispatch.Cursor,
+		dispatch.Shutdown,
+	}
+}
+
+// ServeEvent handles incoming messages from the dispatcher on the source's loop
+func (m *Mem) ServeEvent(e dispatch.Event) (err error) {
+	if stopErr := m.loop.do(func() { err = m.serveEvent(e) }); stopErr != nil {
+		return stopErr
+	}
+	return err
+}
+
+func (m *Mem) serveEvent(e dispatch.Event) error {
+	switch e := e.(type) {
+	case *dispatch.MemAddEvent:
+		return m.addFile(e)
+	case *dispatch.MemEditEvent:
+		return m.editFile(e)
+	case *dispatch.FileDeleteEvent:
+		return m.delFile(e.Path)
+	case *dispatch.AddClientEvent:
+		return m.addClient(e.Client)
+	case *dispatch.DelClientEvent:
+		return m.delClient(e.Client)
+	case *dispatch.ResyncClientEvent:
+		return m.resyncClient(e.Client)
+	case *dispatch.CursorEvent:
+		return m.cursor(e)
+	case *dispatch.ShutdownEvent:
 		return m.close()
 	}
 	return nil
@@ -65,77 +191,220 @@ func (m *Mem) Wait() {
 	<-m.done
 }
 
-func (m *Mem) addFile(r interface{}) error {
-	v := reflect.ValueOf(r)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+func (m *Mem) addFile(e *dispatch.MemAddEvent) error {
+	version, err := m.render(e.ID, e.Data, e.Renderer, e.History)
+	if err != nil {
+		return err
+	}
+	reply(e.Reply, version)
+	return nil
+}
+
+// editFile applies edits to the text of a file, as long as they were made to
+// its current version
+func (m *Mem) editFile(e *dispatch.MemEditEvent) error {
+	buf, ok := m.buffers[getID(e.ID)]
+	if !ok || buf.current().version != e.Base {
+		conflict := &dispatch.ConflictError{ID: e.ID, Base: e.Base}
+		if ok {
+			conflict.Version = buf.current().version
+		}
+		return conflict
+	}
+	text, err := applyEdits(buf.current().text, e.Edits)
+	if err != nil {
+		return err
+	}
+	version, err := m.render(e.ID, text, buf.renderer, nil)
+	if err != nil {
+		return err
+	}
+	reply(e.Reply, version)
+	return nil
+}
+
+// reply sends the new version of a file to the sender of an event. The event
+// is shared with every other handler, so the version is not stored on it.
+func reply(ch chan<- int, version int) {
+	if ch == nil {
+		return
 	}
-	vID := v.FieldByName("ID")
-	vData := v.FieldByName("Data")
+	select {
+	case ch <- version:
+	default:
+		log.Printf("memory warning: nobody is waiting for the version: version=%d\n", version)
+	}
+}
 
-	if vID.Kind() == reflect.Invalid || vData.Kind() == reflect.Invalid {
-		return fmt.Errorf("memory error: did not receive ID or Data: id=%v; data=;%v", vID, vData)
+// render renders the new text of a file and sends it to the file's clients,
+// returning the new version of the text. A nil history keeps the depth the
+// file already has, or the default one for a new file.
+func (m *Mem) render(id string, data []byte, rendererName string, history *int) (int, error) {
+	if err := m.show(id, data, rendererName); err != nil {
+		return 0, err
 	}
 
-	// get the id
-	id := vID.String()
+	// the markdown is kept rather than the render so that every send
+	// replaces what new clients are shown
+	uniqueID := getID(id)
+	buf, ok := m.buffers[uniqueID]
+	if !ok {
+		log.Printf("memory status: now tracking file: id=%q\n", uniqueID)
+		buf = &buffer{history: m.history}
+		m.buffers[uniqueID] = buf
+	}
+	if history != nil {
+		buf.history = *history
+	}
+	version := buf.push(data)
+	buf.renderer = rendererName
+	m.persist(uniqueID)
+	return version, nil
+}
 
-	// extract the bytes slice
-	data := vData.Bytes()
+// show renders the text of a file and sends it to the file's clients
+func (m *Mem) show(id string, data []byte, rendererName string) error {
+	// an explicitly requested renderer takes precedence over the id's extension
+	renderer, err := m.renderers.Select(rendererName, id)
+	if err != nil {
+		return err
+	}
 
 	// markdownify
-	mData := string(md.Markdown(data))
+	result, err := renderer.Render(data)
+	if err != nil {
+		return fmt.Errorf("memory error: could not render data: id=%q; err=%q", id, err)
+	}
 
 	uniqueID := getID(id)
-	if _, ok := m.watching[uniqueID]; !ok {
+	doc, ok := m.docs[uniqueID]
+	if !ok {
 		log.Printf("memory status: now accepting clients: id=%q\n", uniqueID)
-		m.watching[uniqueID] = make(map[*websocket.Conn]struct{})
+		doc = newDocument(server.SourceMemory, id)
+		m.docs[uniqueID] = doc
 	}
+	doc.update(result)
+	m.expireWhenIdle(uniqueID, doc)
+	changed(m.dispatcher, uniqueID)
+	return nil
+}
 
-	if _, ok := m.memData[uniqueID]; !ok {
-		log.Printf("memory status: now tracking file: id=%q\n", uniqueID)
-		m.memData[uniqueID] = mData
+// expireWhenIdle forgets a file once it has not been sent or looked at for a
+// while
+func (m *Mem) expireWhenIdle(uniqueID string, doc *document) {
+	doc.expireWhenIdle(m.loop, m.expireAfter, func() {
+		log.Printf("memory status: forgetting idle file: id=%q; after=%v\n", uniqueID, m.expireAfter)
+		m.delFile(doc.name)
+	})
+}
+
+// persist stores the file in a moment, along with any others that change in
+// the meantime
+func (m *Mem) persist(uniqueID string) {
+	if m.store == nil {
+		return
+	}
+	m.dirty[uniqueID] = struct{}{}
+	if !m.flushing {
+		m.flushing = true
+		time.AfterFunc(storeInterval, func() { m.loop.do(m.flush) })
 	}
+}
 
-	for client := range m.watching[uniqueID] {
-		websocket.JSON.Send(client, RenderFormat{
-			Render: mData,
-		})
+// flush stores the files that changed and evicts the stale ones
+func (m *Mem) flush() {
+	m.flushing = false
+	if m.store == nil || len(m.dirty) == 0 {
+		return
+	}
+	for uniqueID := range m.dirty {
+		buf, ok := m.buffers[uniqueID]
+		doc, tracked := m.docs[uniqueID]
+		if !ok || !tracked {
+			continue
+		}
+		rev := buf.current()
+		snap := &snapshot{
+			ID:       doc.name,
+			Renderer: buf.renderer,
+			Version:  rev.version,
+			Updated:  rev.updated,
+			Text:     string(rev.text),
+		}
+		// only a depth sent with the file outlives a change of the default
+		if buf.history != m.history {
+			depth := buf.history
+			snap.History = &depth
+		}
+		if err := m.store.save(uniqueID, snap); err != nil {
+			log.Printf("memory error: could not store file: id=%q; err=%q\n", uniqueID, err)
+		}
+	}
+	m.dirty = make(map[string]struct{})
+	if err := m.store.evict(); err != nil {
+		log.Printf("memory warning: %v\n", err)
 	}
-	return nil
 }
 
 func (m *Mem) delFile(id string) error {
 	uniqueID := getID(id)
-	if watching, ok := m.watching[uniqueID]; ok {
+	if doc, ok := m.docs[uniqueID]; ok {
 		log.Printf("memory status: untracking file: id=%q\n", uniqueID)
-		for client := range watching {
-			client.Close()
-		}
-		delete(m.watching, uniqueID)
+		doc.close()
+		delete(m.docs, uniqueID)
+		changed(m.dispatcher, uniqueID)
 	}
 
-	delete(m.memData, uniqueID)
+	delete(m.buffers, uniqueID)
+	delete(m.dirty, uniqueID)
+	if m.store != nil {
+		if err := m.store.remove(uniqueID); err != nil {
+			log.Printf("memory warning: could not remove stored file: id=%q; err=%q\n", uniqueID, err)
+		}
+	}
 	return nil
 }
 
-func (m *Mem) addClient(r *server.WebsocketRequest) error {
-	mdata, ok := m.memData[r.ID]
+func (m *Mem) addClient(r *dispatch.Client) error {
+	doc, ok := m.docs[r.ID]
 	if !ok {
 		log.Printf("memory error: could not find memory file to retrieve: id=%q\n", r.ID)
 		return nil
 	}
 
-	watching := m.watching[r.ID]
-	if _, ok := watching[r.WS]; !ok {
-		log.Printf("memory status: adding client to the watch list: id=%q\n", r.ID)
-		watching[r.WS] = struct{}{}
+	log.Printf("memory status: adding client to the watch list: id=%q\n", r.ID)
+	doc.addClient(r.WS)
+	changed(m.dispatcher, r.ID)
+	return nil
+}
+
+func (m *Mem) delClient(r *dispatch.Client) error {
+	doc, ok := m.docs[r.ID]
+	if !ok {
+		return nil
+	}
+	removed := doc.removeClient(r.WS)
+	if removed {
+		log.Printf("memory status: removing client from the watch list: id=%q\n", r.ID)
+		changed(m.dispatcher, r.ID)
+	}
+	if len(doc.clients) == 0 && (removed || doc.idle == nil) {
+		m.expireWhenIdle(r.ID, doc)
+	}
+	return nil
+}
+
+func (m *Mem) resyncClient(r *dispatch.Client) error {
+	if doc, ok := m.docs[r.ID]; ok {
+		doc.resync(r.WS)
 	}
+	return nil
+}
 
-	log.Printf("memory status: updating client with the markdown data: id=%q\n", r.ID)
-	websocket.JSON.Send(r.WS, RenderFormat{
-		Render: mdata,
-	})
+func (m *Mem) cursor(e *dispatch.CursorEvent) error {
+	if doc, ok := m.docs[e.ID]; ok {
+		doc.cursor(e.Line)
+	}
 	return nil
 }
 
@@ -144,11 +413,11 @@ func (m *Mem) getID(id string) string {
 }
 
 func (m *Mem) close() error {
-	for _, watcher := range m.watching {
-		for client := range watcher {
-			client.Close()
-		}
+	m.flush()
+	for _, doc := range m.docs {
+		doc.close()
 	}
+	m.loop.stop()
 
 	close(m.done)
 	return nil
@@ -0,0 +1,145 @@
+package sources
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/davinche/godown/dispatch"
+)
+
+// send replaces the text of an in-memory file as often as asked
+func (s *testSources) send(t *testing.T, id string, times int, history *int) {
+	t.Helper()
+	for i := 0; i < times; i++ {
+		e := &dispatch.MemAddEvent{ID: id, Data: []byte("# version"), History: history}
+		if err := s.await(t, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// kept returns the versions of an in-memory file that can still be read
+func (s *testSources) kept(t *testing.T, id string) []int {
+	t.Helper()
+	uid := getID(id)
+	_, current, err := s.mem.Source(uid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []int{}
+	for version := 1; version <= current; version++ {
+		if _, _, err := s.mem.Source(uid, version); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+func TestMemHistory(t *testing.T) {
+	s := newTestSources(t)
+	s.mem.KeepHistory(2)
+	deep, none := 4, 0
+
+	s.send(t, "default.md", 6, nil)
+	s.send(t, "deep.md", 6, &deep)
+	s.send(t, "none.md", 3, &none)
+	tests := []struct {
+		id   string
+		want []int
+	}{
+		{"default.md", []int{4, 5, 6}},
+		{"deep.md", []int{2, 3, 4, 5, 6}},
+		{"none.md", []int{3}},
+	}
+	for _, test := range tests {
+		if got := s.kept(t, test.id); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got versions %v, want %v", test.id, got, test.want)
+		}
+	}
+
+	// a file sent again without a depth keeps its own
+	s.send(t, "deep.md", 1, nil)
+	if got, want := s.kept(t, "deep.md"), []int{3, 4, 5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deep.md sent again: got versions %v, want %v", got, want)
+	}
+	// and so does a file that is edited
+	edit := &dispatch.MemEditEvent{ID: "none.md", Base: 3, Edits: []dispatch.Edit{{Offset: 0, Length: 1, Text: "#"}}}
+	if err := s.await(t, edit); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.kept(t, "none.md"), []int{4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("none.md edited: got versions %v, want %v", got, want)
+	}
+}
+
+func TestMemHistoryStored(t *testing.T) {
+	store := NewStore(t.TempDir(), 0, 0)
+	deep := 4
+
+	s := newTestSources(t)
+	s.mem.UseStore(store)
+	s.send(t, "default.md", 1, nil)
+	s.send(t, "deep.md", 1, &deep)
+	s.mem.loop.do(s.mem.flush)
+
+	// the default depth is the one the restoring daemon has
+	restored := newTestSources(t)
+	restored.mem.KeepHistory(1)
+	restored.mem.UseStore(store)
+	restored.mem.Restore()
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{"default.md", 1},
+		{"deep.md", 4},
+	}
+	for _, test := range tests {
+		var got int
+		restored.mem.loop.do(func() {
+			if buf, ok := restored.mem.buffers[getID(test.id)]; ok {
+				got = buf.history
+			}
+		})
+		if got != test.want {
+			t.Errorf("%s: got depth %d, want %d", test.id, got, test.want)
+		}
+	}
+}
+
+func TestMemReply(t *testing.T) {
+	s := newTestSources(t)
+	// every handler is handed the same event, and reads it as it likes
+	s.dispatcher.AddHandlerFunc(func(e dispatch.Event) error {
+		_ = fmt.Sprintf("%+v", e)
+		return nil
+	})
+
+	reply := make(chan int, 1)
+	add := &dispatch.MemAddEvent{ID: "reply.md", Data: []byte("# version"), Reply: reply}
+	if err := s.await(t, add); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-reply; got != 1 {
+		t.Errorf("add: got version %d, want 1", got)
+	}
+	edit := &dispatch.MemEditEvent{ID: "reply.md", Base: 1, Edits: []dispatch.Edit{{Offset: 0, Length: 1, Text: "#"}}, Reply: reply}
+	if err := s.await(t, edit); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-reply; got != 2 {
+		t.Errorf("edit: got version %d, want 2", got)
+	}
+
+	// a stale edit sends nothing back
+	edit = &dispatch.MemEditEvent{ID: "reply.md", Base: 1, Edits: []dispatch.Edit{{Offset: 0, Length: 1, Text: "#"}}, Reply: reply}
+	if _, ok := s.await(t, edit).(*dispatch.ConflictError); !ok {
+		t.Error("a stale edit was not refused")
+	}
+	select {
+	case version := <-reply:
+		t.Errorf("stale edit: got version %d", version)
+	default:
+	}
+}
@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package sources
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// the events on the parent directory that may indicate the file has changed.
+// Watching the directory instead of the file itself catches editors that save
+// by writing a temporary file and renaming it over the original.
+const notifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// notify uses inotify to signal whenever the file at path might have changed.
+// The returned channel is closed once the directory can no longer be watched.
+func notify(path string, done chan struct{}) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	dir, name := filepath.Split(path)
+	if _, err := syscall.InotifyAddWatch(fd, dir, notifyMask); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// wrapping the non-blocking descriptor lets Close interrupt a pending Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-done
+		file.Close()
+	}()
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+				// the directory itself went away
+				if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+					return
+				}
+				if string(bytes.TrimRight(nameBytes, "\x00")) != name && event.Mask&syscall.IN_Q_OVERFLOW == 0 {
+					continue
+				}
+
+				// coalesce events; the watcher debounces them anyway
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package sources
+
+import "errors"
+
+// notify is not supported on this platform so the watcher polls instead
+func notify(path string, done chan struct{}) (<-chan struct{}, error) {
+	return nil, errors.New("file notifications are not supported on this platform")
+}
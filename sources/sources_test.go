@@ -0,0 +1,270 @@
+package sources
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/davinche/godown/dispatch"
+	"github.com/davinche/godown/render"
+)
+
+// testSources are the sources of a daemon, without its servers
+type testSources struct {
+	dispatcher *dispatch.Dispatcher
+	file       *File
+	mem        *Mem
+	dir        *Dir
+}
+
+func newTestSources(t *testing.T) *testSources {
+	t.Helper()
+	d := dispatch.NewDispatcher()
+	renderers := render.NewRegistry()
+	s := &testSources{
+		dispatcher: d,
+		file:       NewFile(d, renderers),
+		mem:        NewMem(d, renderers),
+		dir:        NewDir(d, renderers),
+	}
+	d.AddHandler(s.file)
+	d.AddHandler(s.mem)
+	d.AddHandler(s.dir)
+	t.Cleanup(func() {
+		d.Dispatch(&dispatch.ShutdownEvent{})
+		s.file.Wait()
+		s.mem.Wait()
+		s.dir.Wait()
+	})
+	return s
+}
+
+// await dispatches an event and returns the error of the first handler that
+// failed to serve it
+func (s *testSources) await(t *testing.T, e dispatch.Event) error {
+	t.Helper()
+	done, errCh := s.dispatcher.Dispatch(e)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out serving %s", e.EventType())
+	}
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// eventually fails the test unless cond becomes true within a few seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourcesConcurrently(t *testing.T) {
+	s := newTestSources(t)
+	root := t.TempDir()
+	files := filepath.Join(root, "files")
+	tree := filepath.Join(root, "tree")
+	for _, dir := range []string{files, filepath.Join(tree, "sub")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		writeFile(t, filepath.Join(files, fmt.Sprintf("%d.md", i)), "# File\n")
+		writeFile(t, filepath.Join(tree, "sub", fmt.Sprintf("%d.md", i)), "# Tree\n")
+	}
+
+	var wg sync.WaitGroup
+	run := func(n int, f func(i int)) {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				f(i)
+			}(i)
+		}
+	}
+
+	// watched files, added and changed
+	run(4, func(i int) {
+		path := filepath.Join(files, fmt.Sprintf("%d.md", i))
+		if err := s.await(t, &dispatch.FileAddEvent{Path: path}); err != nil {
+			t.Errorf("adding %s: %v", path, err)
+		}
+		for j := 0; j < 5; j++ {
+			writeFile(t, path, fmt.Sprintf("# File\n\nchange %d\n", j))
+		}
+	})
+
+	// a previewed directory, which adds its files to the File source
+	run(2, func(i int) {
+		if err := s.await(t, &dispatch.FileAddEvent{Path: tree}); err != nil {
+			t.Errorf("adding %s: %v", tree, err)
+		}
+	})
+
+	// in-memory files, sent in full and edited
+	run(4, func(i int) {
+		id := fmt.Sprintf("buffer-%d", i%2)
+		for j := 0; j < 10; j++ {
+			reply := make(chan int, 1)
+			add := &dispatch.MemAddEvent{ID: id, Data: []byte(fmt.Sprintf("# Buffer\n\n%d\n", j)), Reply: reply}
+			if err := s.await(t, add); err != nil {
+				t.Errorf("sending %s: %v", id, err)
+				return
+			}
+			edit := &dispatch.MemEditEvent{
+				ID:    id,
+				Base:  <-reply,
+				Edits: []dispatch.Edit{{Offset: 2, Length: 6, Text: "Edited"}},
+			}
+			// other senders of the same file make some of the edits stale
+			if err := s.await(t, edit); err != nil {
+				if _, ok := err.(*dispatch.ConflictError); !ok {
+					t.Errorf("editing %s: %v", id, err)
+				}
+			}
+		}
+	})
+
+	// the dashboard and the file server look the documents up meanwhile
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s.file.Documents()
+				s.mem.Documents()
+				s.dir.Documents()
+				s.mem.Source(getID("buffer-0"), 0)
+				s.file.AssetRoot(getID(filepath.Join(files, "0.md")))
+				s.dir.GetID(tree)
+			}
+		}()
+	}
+
+	wg.Wait()
+	eventually(t, "the files of the directory", func() bool {
+		return len(s.file.Documents()) == 8
+	})
+	close(stop)
+	readers.Wait()
+
+	if docs := s.dir.Documents(); len(docs) != 1 {
+		t.Errorf("got %d directories, want 1", len(docs))
+	}
+	if docs := s.mem.Documents(); len(docs) != 2 {
+		t.Errorf("got %d in-memory files, want 2", len(docs))
+	}
+	for i := 0; i < 2; i++ {
+		text, version, err := s.mem.Source(getID(fmt.Sprintf("buffer-%d", i)), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version < 10 || !strings.HasPrefix(string(text), "# ") {
+			t.Errorf("buffer-%d: got version %d with %q", i, version, text)
+		}
+	}
+}
+
+// browse opens a websocket that stands in for a browser showing a document
+func browse(t *testing.T) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ioutil.Discard, ws)
+	}))
+	t.Cleanup(srv.Close)
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func TestIdleDirectoriesExpire(t *testing.T) {
+	s := newTestSources(t)
+	s.file.ExpireAfter(50 * time.Millisecond)
+	s.dir.ExpireAfter(50 * time.Millisecond)
+	root := t.TempDir()
+	tree := filepath.Join(root, "tree")
+	if err := os.MkdirAll(tree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inTree := filepath.Join(tree, "a.md")
+	alone := filepath.Join(root, "b.md")
+	writeFile(t, inTree, "# A\n")
+	writeFile(t, alone, "# B\n")
+
+	for _, path := range []string{tree, alone} {
+		if err := s.await(t, &dispatch.FileAddEvent{Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the files to be watched", func() bool {
+		return len(s.file.Documents()) == 2
+	})
+
+	// a browser shows a file of the directory while the index and the other
+	// file go without one
+	browser := &dispatch.Client{ID: getID(inTree), WS: browse(t)}
+	if err := s.await(t, &dispatch.AddClientEvent{Client: browser}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{tree, alone} {
+		if err := s.await(t, &dispatch.DelClientEvent{Client: &dispatch.Client{ID: getID(path)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the idle file to be untracked", func() bool {
+		return len(s.file.Documents()) == 1
+	})
+	time.Sleep(200 * time.Millisecond)
+	if docs := s.dir.Documents(); len(docs) != 1 {
+		t.Errorf("the directory was untracked while one of its files was shown")
+	}
+	if _, err := s.file.AssetRoot(getID(inTree)); err != nil {
+		t.Errorf("the file of the directory was untracked while shown: %v", err)
+	}
+
+	// once the browser goes away, the directory expires along with its files
+	if err := s.await(t, &dispatch.DelClientEvent{Client: browser}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the idle directory to be untracked", func() bool {
+		return len(s.dir.Documents()) == 0 && len(s.file.Documents()) == 0
+	})
+}
@@ -0,0 +1,177 @@
+package sources
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for how long and how much of the in-memory files are kept on disk
+const (
+	DefaultStoreAge  = 7 * 24 * time.Hour
+	DefaultStoreSize = 64 << 20
+)
+
+// how long changes to in-memory files are gathered before they are written
+const storeInterval = 2 * time.Second
+
+// Store keeps snapshots of in-memory files on disk so that they survive the
+// daemon. Snapshots older than the maximum age are evicted, and so are the
+// oldest ones while they take up more than the maximum size; zero means no
+// limit.
+type Store struct {
+	dir     string
+	maxAge  time.Duration
+	maxSize int64
+}
+
+// snapshot is an in-memory file as it is stored on disk
+type snapshot struct {
+	ID       string    `json:"id"`
+	Renderer string    `json:"renderer,omitempty"`
+	History  *int      `json:"history,omitempty"`
+	Version  int       `json:"version"`
+	Updated  time.Time `json:"updated"`
+	Text     string    `json:"text"`
+}
+
+// NewStore is the constructor for a store of snapshots in a directory
+func NewStore(dir string, maxAge time.Duration, maxSize int64) *Store {
+	return &Store{dir: dir, maxAge: maxAge, maxSize: maxSize}
+}
+
+// StorePath returns the directory the in-memory files of the daemon on a port
+// are stored in, under the user's state directory
+func StorePath(port int) string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "godown", "buffers", strconv.Itoa(port))
+}
+
+func (s *Store) path(uniqueID string) string {
+	return filepath.Join(s.dir, uniqueID+".json")
+}
+
+// save writes the snapshot of an in-memory file, replacing the previous one
+// in a single step so that a crash never leaves half of it behind
+func (s *Store) save(uniqueID string, snap *snapshot) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, uniqueID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(uniqueID))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// remove deletes the snapshot of an in-memory file
+func (s *Store) remove(uniqueID string) error {
+	if err := os.Remove(s.path(uniqueID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// load evicts the snapshots that are too old or too many, and returns the
+// rest by unique id
+func (s *Store) load() (map[string]*snapshot, error) {
+	snaps := make(map[string]*snapshot)
+	if err := s.evict(); err != nil {
+		return snaps, err
+	}
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snaps, nil
+		}
+		return snaps, err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			log.Printf("store warning: cannot read snapshot: name=%q; err=%q\n", file.Name(), err)
+			continue
+		}
+		snap := &snapshot{}
+		if err := json.Unmarshal(data, snap); err != nil {
+			log.Printf("store warning: cannot parse snapshot: name=%q; err=%q\n", file.Name(), err)
+			continue
+		}
+		snaps[strings.TrimSuffix(file.Name(), ".json")] = snap
+	}
+	return snaps, nil
+}
+
+// evict removes the snapshots that were written too long ago, then the oldest
+// ones until the rest fit in the maximum size
+func (s *Store) evict() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// newest first, so that whatever is past the limits is at the end
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	var size int64
+	var errs []string
+	for _, file := range files {
+		name := file.Name()
+		path := filepath.Join(s.dir, name)
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			// left behind by a crash while saving
+		case !strings.HasSuffix(name, ".json"):
+			continue
+		case s.maxAge > 0 && time.Since(file.ModTime()) > s.maxAge:
+			log.Printf("store status: evicting old snapshot: name=%q; written=%v\n", name, file.ModTime())
+		case s.maxSize > 0 && size+file.Size() > s.maxSize:
+			log.Printf("store status: evicting snapshot over the size limit: name=%q; size=%d\n", name, file.Size())
+		default:
+			size += file.Size()
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("store error: could not evict snapshots: errs=%q", errs)
+	}
+	return nil
+}